@@ -20,3 +20,54 @@ import (
 type Clock interface {
 	Now() time.Time
 }
+
+// TimerClock is a Clock that can also wait.  It mirrors the waiting functions
+// of the standard time package, so code that sleeps or sets timers can be
+// driven by the system clock in production and by a ManualClock in test.
+//
+// SystemClock satisfies this interface using the standard time package.
+// ManualClock satisfies it with a time that only moves when Advance is
+// called.
+//
+type TimerClock interface {
+	Clock
+	// After waits for the duration to elapse and then sends the current time
+	// on the returned channel.
+	After(d time.Duration) <-chan time.Time
+	// Sleep pauses the calling goroutine for at least the duration d.
+	Sleep(d time.Duration)
+	// NewTimer creates a Timer that will send the current time on its
+	// channel after at least duration d.
+	NewTimer(d time.Duration) Timer
+	// NewTicker returns a Ticker that sends the time on its channel
+	// at intervals of d.
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc waits for the duration to elapse and then calls f.  It
+	// returns a Timer that can be used to cancel the call.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the equivalent of a time.Timer.  The channel is supplied by a
+// method rather than a field so that the interface can be satisfied by a
+// fake.
+type Timer interface {
+	// C returns the channel on which the time is delivered.  A Timer
+	// created by AfterFunc has no channel and returns nil.
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing.  It returns true if the call
+	// stops the timer, false if the timer has already expired or been
+	// stopped.
+	Stop() bool
+	// Reset changes the timer to expire after duration d.  It returns true
+	// if the timer had been active, false if the timer had expired or been
+	// stopped.
+	Reset(d time.Duration) bool
+}
+
+// Ticker is the equivalent of a time.Ticker.
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the ticker.  No more ticks will be sent.
+	Stop()
+}
@@ -0,0 +1,259 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ManualClock is a TimerClock whose time only moves when the caller says so.
+// It's intended for testing code that sleeps or sets timers.
+//
+// Timers, tickers, After, AfterFunc and Sleep all register a waiter with the
+// clock.  Advance moves the time forward and fires every waiter that falls
+// due, in time order.  The clock's time is set to each deadline as it fires,
+// so a waiter that calls Now sees the time at which it fired.  Functions
+// supplied to AfterFunc are called by the goroutine that calls Advance, so
+// once Advance returns, their work is done.
+//
+// A test that starts a goroutine which sleeps can call BlockUntil to wait
+// until the goroutine is sleeping before it calls Advance.
+type ManualClock struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*manualWaiter // Pending waiters, in no particular order.
+	nextSeq uint64          // Used to fire waiters with equal deadlines in creation order.
+}
+
+var _ TimerClock = (*ManualClock)(nil)
+
+// manualWaiter is a timer, ticker or AfterFunc registered with a ManualClock.
+type manualWaiter struct {
+	clock    *ManualClock
+	deadline time.Time
+	period   time.Duration  // Non-zero for a ticker.
+	seq      uint64         // Creation order.
+	c        chan time.Time // Nil for AfterFunc.
+	f        func()         // Non-nil for AfterFunc.
+	active   bool           // True while the waiter is registered.
+}
+
+// NewManualClock creates a ManualClock set to the given time.
+func NewManualClock(start time.Time) *ManualClock {
+	c := ManualClock{now: start}
+	c.cond = sync.NewCond(&c.mutex)
+	return &c
+}
+
+// Now returns the clock's current time.
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, firing all waiters that fall due,
+// in deadline order.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	target := c.now.Add(d)
+	c.mutex.Unlock()
+	c.AdvanceTo(target)
+}
+
+// AdvanceTo moves the clock forward to the time t, firing all waiters
+// that fall due, in deadline order.  If t is before the clock's current
+// time, the clock doesn't change.
+func (c *ManualClock) AdvanceTo(t time.Time) {
+	for {
+		c.mutex.Lock()
+		w := c.nextDue(t)
+		if w == nil {
+			if t.After(c.now) {
+				c.now = t
+			}
+			c.mutex.Unlock()
+			return
+		}
+
+		// Move the time to the waiter's deadline and fire it.
+		if w.deadline.After(c.now) {
+			c.now = w.deadline
+		}
+		f := c.fire(w)
+		c.mutex.Unlock()
+
+		// Call any AfterFunc function without the lock, so that it can
+		// use the clock.
+		if f != nil {
+			f()
+		}
+	}
+}
+
+// WaiterCount returns the number of pending timers, tickers and sleepers.
+func (c *ManualClock) WaiterCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil blocks until the clock has at least n pending timers, tickers
+// and sleepers.
+func (c *ManualClock) BlockUntil(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// After returns a channel that receives the time once the clock has been
+// advanced by d.
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// Sleep blocks until the clock has been advanced by d.
+func (c *ManualClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// NewTimer creates a Timer that fires once the clock has been advanced by d.
+func (c *ManualClock) NewTimer(d time.Duration) Timer {
+	w := &manualWaiter{clock: c, c: make(chan time.Time, 1)}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.add(w, d)
+	return w
+}
+
+// NewTicker creates a Ticker that fires each time the clock passes another
+// multiple of d.  It panics if d is not positive.
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for ManualClock.NewTicker")
+	}
+	w := &manualWaiter{clock: c, period: d, c: make(chan time.Time, 1)}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.add(w, d)
+	return &manualTicker{w}
+}
+
+// AfterFunc arranges for f to be called once the clock has been advanced by d.
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) Timer {
+	w := &manualWaiter{clock: c, f: f}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.add(w, d)
+	return w
+}
+
+// add registers the waiter to fire d after the current time.
+func (c *ManualClock) add(w *manualWaiter, d time.Duration) {
+	w.deadline = c.now.Add(d)
+	w.seq = c.nextSeq
+	c.nextSeq++
+	w.active = true
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+}
+
+// remove unregisters the waiter.  It returns true if the waiter was
+// registered.
+func (c *ManualClock) remove(w *manualWaiter) bool {
+	if !w.active {
+		return false
+	}
+	for i, x := range c.waiters {
+		if x == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			break
+		}
+	}
+	w.active = false
+	return true
+}
+
+// nextDue returns the waiter with the earliest deadline that is not after
+// t, or nil if there isn't one.
+func (c *ManualClock) nextDue(t time.Time) *manualWaiter {
+	if len(c.waiters) == 0 {
+		return nil
+	}
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		a, b := c.waiters[i], c.waiters[j]
+		if a.deadline.Equal(b.deadline) {
+			return a.seq < b.seq
+		}
+		return a.deadline.Before(b.deadline)
+	})
+	if c.waiters[0].deadline.After(t) {
+		return nil
+	}
+	return c.waiters[0]
+}
+
+// fire delivers the waiter's event.  A ticker is rescheduled for its next
+// tick, anything else is unregistered.  If the waiter was created by
+// AfterFunc, fire returns the function, which the caller should call once
+// it has released the lock.
+func (c *ManualClock) fire(w *manualWaiter) func() {
+	if w.period > 0 {
+		w.deadline = w.deadline.Add(w.period)
+	} else {
+		c.remove(w)
+	}
+
+	if w.f != nil {
+		return w.f
+	}
+
+	// Like the standard library, drop the event if the receiver
+	// hasn't collected the last one.
+	select {
+	case w.c <- c.now:
+	default:
+	}
+	return nil
+}
+
+// C returns the channel on which the time is delivered.
+func (w *manualWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+// Stop stops the timer or ticker.
+func (w *manualWaiter) Stop() bool {
+	w.clock.mutex.Lock()
+	defer w.clock.mutex.Unlock()
+	return w.clock.remove(w)
+}
+
+// Reset changes the timer to fire once the clock has been advanced by d
+// from its current time.
+func (w *manualWaiter) Reset(d time.Duration) bool {
+	w.clock.mutex.Lock()
+	defer w.clock.mutex.Unlock()
+	wasActive := w.clock.remove(w)
+	w.clock.add(w, d)
+	return wasActive
+}
+
+// manualTicker satisfies the Ticker interface.  It's a separate type from
+// manualWaiter because Ticker.Stop doesn't return a value.
+type manualTicker struct {
+	waiter *manualWaiter
+}
+
+// C returns the channel on which the ticks are delivered.
+func (t *manualTicker) C() <-chan time.Time {
+	return t.waiter.c
+}
+
+// Stop turns off the ticker.
+func (t *manualTicker) Stop() {
+	t.waiter.Stop()
+}
@@ -0,0 +1,109 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+// TestManualClockFiresInOrder checks that Advance fires pending waiters in
+// deadline order and sets the time to each deadline as it fires.
+func TestManualClockFiresInOrder(t *testing.T) {
+	locationUTC, _ := time.LoadLocation("UTC")
+	start := time.Date(2020, time.February, 14, 23, 0, 0, 0, locationUTC)
+	clock := NewManualClock(start)
+
+	var fired []time.Time
+	clock.AfterFunc(3*time.Minute, func() { fired = append(fired, clock.Now()) })
+	clock.AfterFunc(time.Minute, func() { fired = append(fired, clock.Now()) })
+	stopped := clock.AfterFunc(2*time.Minute, func() { fired = append(fired, clock.Now()) })
+	timer := clock.NewTimer(2 * time.Minute)
+
+	if !stopped.Stop() {
+		t.Fatal("Stop on a pending timer returned false")
+	}
+
+	clock.Advance(time.Hour)
+
+	if len(fired) != 2 {
+		t.Fatalf("expected 2 functions to be called, got %d", len(fired))
+	}
+	if !fired[0].Equal(start.Add(time.Minute)) {
+		t.Errorf("first function called at %v - expected %v", fired[0], start.Add(time.Minute))
+	}
+	if !fired[1].Equal(start.Add(3 * time.Minute)) {
+		t.Errorf("second function called at %v - expected %v", fired[1], start.Add(3*time.Minute))
+	}
+
+	select {
+	case tm := <-timer.C():
+		if !tm.Equal(start.Add(2 * time.Minute)) {
+			t.Errorf("timer delivered %v - expected %v", tm, start.Add(2*time.Minute))
+		}
+	default:
+		t.Error("timer did not fire")
+	}
+
+	if !clock.Now().Equal(start.Add(time.Hour)) {
+		t.Errorf("clock reads %v - expected %v", clock.Now(), start.Add(time.Hour))
+	}
+
+	if clock.WaiterCount() != 0 {
+		t.Errorf("expected no waiters, got %d", clock.WaiterCount())
+	}
+}
+
+// TestManualClockTicker checks that a ticker fires once for each interval
+// and stops when told to.
+func TestManualClockTicker(t *testing.T) {
+	locationUTC, _ := time.LoadLocation("UTC")
+	start := time.Date(2020, time.February, 14, 23, 0, 0, 0, locationUTC)
+	clock := NewManualClock(start)
+
+	ticker := clock.NewTicker(10 * time.Second)
+	for i := 1; i <= 3; i++ {
+		clock.Advance(10 * time.Second)
+		tm := <-ticker.C()
+		expected := start.Add(time.Duration(i) * 10 * time.Second)
+		if !tm.Equal(expected) {
+			t.Errorf("tick %d delivered %v - expected %v", i, tm, expected)
+		}
+	}
+
+	ticker.Stop()
+	clock.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Error("stopped ticker fired")
+	default:
+	}
+}
+
+// TestManualClockSleep checks that Sleep returns once the clock has been
+// advanced far enough.
+func TestManualClockSleep(t *testing.T) {
+	locationUTC, _ := time.LoadLocation("UTC")
+	start := time.Date(2020, time.February, 14, 23, 0, 0, 0, locationUTC)
+	clock := NewManualClock(start)
+
+	done := make(chan time.Time)
+	go func() {
+		clock.Sleep(time.Hour)
+		done <- clock.Now()
+	}()
+
+	// Wait for the goroutine to go to sleep.
+	clock.BlockUntil(1)
+
+	clock.Advance(59 * time.Minute)
+	select {
+	case <-done:
+		t.Fatal("Sleep returned early")
+	default:
+	}
+
+	clock.Advance(time.Minute)
+	woke := <-done
+	if !woke.Equal(start.Add(time.Hour)) {
+		t.Errorf("sleeper woke at %v - expected %v", woke, start.Add(time.Hour))
+	}
+}
@@ -8,6 +8,8 @@ import (
 type SystemClock struct {
 }
 
+var _ TimerClock = (*SystemClock)(nil)
+
 // NewSystemClock creates a system clock and returns it as a Clock.
 func NewSystemClock() Clock {
 	var systemClock SystemClock
@@ -18,3 +20,63 @@ func NewSystemClock() Clock {
 func (c SystemClock) Now() time.Time {
 	return time.Now()
 }
+
+// After calls time.After.
+func (c SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Sleep calls time.Sleep.
+func (c SystemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// NewTimer creates a Timer that wraps a time.Timer.
+func (c SystemClock) NewTimer(d time.Duration) Timer {
+	return &systemTimer{time.NewTimer(d)}
+}
+
+// NewTicker creates a Ticker that wraps a time.Ticker.
+func (c SystemClock) NewTicker(d time.Duration) Ticker {
+	return &systemTicker{time.NewTicker(d)}
+}
+
+// AfterFunc calls time.AfterFunc and wraps the resulting time.Timer.
+func (c SystemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return &systemTimer{time.AfterFunc(d, f)}
+}
+
+// systemTimer satisfies the Timer interface by wrapping a time.Timer.
+type systemTimer struct {
+	timer *time.Timer
+}
+
+// C returns the channel of the underlying time.Timer.
+func (t *systemTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+// Stop stops the underlying time.Timer.
+func (t *systemTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+// Reset resets the underlying time.Timer.
+func (t *systemTimer) Reset(d time.Duration) bool {
+	return t.timer.Reset(d)
+}
+
+// systemTicker satisfies the Ticker interface by wrapping a time.Ticker.
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+// C returns the channel of the underlying time.Ticker.
+func (t *systemTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+// Stop stops the underlying time.Ticker.
+func (t *systemTicker) Stop() {
+	t.ticker.Stop()
+}
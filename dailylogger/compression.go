@@ -0,0 +1,160 @@
+package dailylogger
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Compression defines how the janitor compresses the log files that the Writer
+// has finished with.  Gzip is the only compression supported at present - the
+// standard library doesn't provide a zstd encoder.
+type Compression int
+
+const (
+	// NoCompression leaves old log files as they are.  This is the default.
+	NoCompression Compression = iota
+	// Gzip compresses old log files with gzip, adding ".gz" to the name.
+	Gzip
+)
+
+// compressedSuffix is added to the name of a compressed log file.
+const compressedSuffix = ".gz"
+
+// temporarySuffix is added to the name of a compressed log file while it's
+// being written.
+const temporarySuffix = ".tmp"
+
+// compressOldLogs compresses the Writer's log files, apart from the current
+// one, that are not already compressed.  This includes files left over from
+// an earlier run.  It's called by the janitor and by unit tests.
+func (dw *Writer) compressOldLogs() {
+
+	dw.logMutex.Lock()
+	compression := dw.compression
+	dw.logMutex.Unlock()
+
+	if compression == NoCompression {
+		return
+	}
+
+	files, err := ioutil.ReadDir(dw.logDir)
+	if err != nil {
+		log.Printf("compressOldLogs: cannot scan log directory %s - %s\n",
+			dw.logDir, err.Error())
+		return
+	}
+
+	// Find the current file after scanning the directory.  If the Writer
+	// rotates in between, the file it has moved to is not in the list.
+	currentName := dw.currentLogName()
+
+	pattern := dw.logFilePattern()
+	for _, file := range files {
+		name := file.Name()
+		if !file.Mode().IsRegular() || !pattern.MatchString(name) ||
+			strings.HasSuffix(name, compressedSuffix) || name == currentName {
+
+			continue
+		}
+
+		err := compressFile(filepath.Join(dw.logDir, name), file.ModTime())
+		if err != nil {
+			log.Printf("compressOldLogs: cannot compress log file %s - %s\n",
+				name, err.Error())
+		}
+	}
+}
+
+// removeTemporaryFiles removes any compressed files left half written by a
+// run that stopped during compression.  It's called when the janitor starts,
+// before it has begun compressing anything.
+func (dw *Writer) removeTemporaryFiles() {
+	files, err := ioutil.ReadDir(dw.logDir)
+	if err != nil {
+		log.Printf("removeTemporaryFiles: cannot scan log directory %s - %s\n",
+			dw.logDir, err.Error())
+		return
+	}
+
+	pattern := dw.logFilePattern()
+	for _, file := range files {
+		name := file.Name()
+		if !file.Mode().IsRegular() || !strings.HasSuffix(name, compressedSuffix+temporarySuffix) ||
+			!pattern.MatchString(strings.TrimSuffix(name, temporarySuffix)) {
+
+			continue
+		}
+		err := os.Remove(filepath.Join(dw.logDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("removeTemporaryFiles: cannot remove %s - %s\n", name, err.Error())
+		}
+	}
+}
+
+// isCompressed returns true if the log file with the given sequence number
+// for the period that starts at the given time has been compressed.
+func (dw *Writer) isCompressed(periodStart time.Time, sequence int) bool {
+	_, err := os.Stat(dw.getLogPathname(periodStart, sequence) + compressedSuffix)
+	return err == nil
+}
+
+// compressFile compresses the named file using gzip.  It writes the result to
+// a temporary file and then renames it, so a crash doesn't leave a partial
+// compressed file behind.  The compressed file is given the modification time
+// of the original, so that the retention rules still apply to it, and then
+// the original is removed.
+func compressFile(pathname string, modTime time.Time) error {
+	compressedName := pathname + compressedSuffix
+	temporaryName := compressedName + temporarySuffix
+
+	in, err := os.Open(pathname)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(temporaryName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(pathname)
+	zw.ModTime = modTime
+
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporaryName)
+		return err
+	}
+
+	err = os.Chtimes(temporaryName, modTime, modTime)
+	if err != nil {
+		os.Remove(temporaryName)
+		return err
+	}
+
+	err = os.Rename(temporaryName, compressedName)
+	if err != nil {
+		os.Remove(temporaryName)
+		return err
+	}
+
+	return os.Remove(pathname)
+}
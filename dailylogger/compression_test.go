@@ -0,0 +1,130 @@
+package dailylogger
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+	ts "github.com/goblimey/go-tools/testsupport"
+)
+
+// TestCompressOldLogs checks that the log files the Writer has finished with are
+// compressed, that the current log file is left alone and that a Writer
+// restarted after its current file has been compressed starts a new file.
+func TestCompressOldLogs(t *testing.T) {
+
+	// This test uses the filestore.
+
+	const oldMessage = "yesterday's news"
+	const currentMessage = "hello"
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	// Simulate a file left uncompressed by an earlier run.
+	err = ioutil.WriteFile("foo.2020-02-13.bar", []byte(oldMessage), 0644)
+	if err != nil {
+		t.Fatalf("cannot create old log file - %v", err)
+	}
+
+	locationUTC, _ := time.LoadLocation("UTC")
+	stoppedClock := clock.NewStoppedClock(2020, time.February, 14, 12, 0, 0, 0, locationUTC)
+	writer := newWriter(stoppedClock, ".", "foo.", ".bar")
+	writer.Write([]byte(currentMessage))
+	writer.compression = Gzip
+
+	writer.compressOldLogs()
+
+	if _, err := os.Stat("foo.2020-02-13.bar"); !os.IsNotExist(err) {
+		t.Error("old log file was not removed after compression")
+	}
+
+	in, err := os.Open("foo.2020-02-13.bar.gz")
+	if err != nil {
+		t.Fatalf("cannot open compressed log file - %v", err)
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		t.Fatalf("cannot read compressed log file - %v", err)
+	}
+	contents, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("cannot decompress log file - %v", err)
+	}
+	if string(contents) != oldMessage {
+		t.Errorf("compressed log file contains \"%s\" - expected \"%s\"", string(contents), oldMessage)
+	}
+
+	contents, err = ioutil.ReadFile("foo.2020-02-14.bar")
+	if err != nil {
+		t.Fatalf("cannot read current log file - %v", err)
+	}
+	if string(contents) != currentMessage {
+		t.Errorf("current log file contains \"%s\" - expected \"%s\"", string(contents), currentMessage)
+	}
+
+	writer.Close()
+
+	// Compress today's file, as if the clock had moved on, and restart.  The
+	// new writer can't append to the compressed file so it starts the next
+	// in the sequence.
+	err = compressFile("foo.2020-02-14.bar", stoppedClock.Now())
+	if err != nil {
+		t.Fatalf("compressFile failed - %v", err)
+	}
+	writer = newWriter(stoppedClock, ".", "foo.", ".bar")
+	writer.Write([]byte(currentMessage))
+	writer.Close()
+
+	contents, err = ioutil.ReadFile("foo.2020-02-14.1.bar")
+	if err != nil {
+		t.Fatalf("cannot read restarted log file - %v", err)
+	}
+	if string(contents) != currentMessage {
+		t.Errorf("restarted log file contains \"%s\" - expected \"%s\"", string(contents), currentMessage)
+	}
+}
+
+// TestRemoveTemporaryFiles checks that compressed files left half written are
+// removed and that other files are left alone.
+func TestRemoveTemporaryFiles(t *testing.T) {
+
+	// This test uses the filestore.
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	names := []string{"foo.2020-02-13.bar.gz.tmp", "foo.2020-02-13.bar", "other.2020-02-13.bar.gz.tmp"}
+	for _, name := range names {
+		err = ioutil.WriteFile(name, []byte("junk"), 0644)
+		if err != nil {
+			t.Fatalf("cannot create %s - %v", name, err)
+		}
+	}
+
+	locationUTC, _ := time.LoadLocation("UTC")
+	stoppedClock := clock.NewStoppedClock(2020, time.February, 14, 12, 0, 0, 0, locationUTC)
+	writer := newWriter(stoppedClock, ".", "foo.", ".bar")
+	defer writer.Close()
+
+	writer.removeTemporaryFiles()
+
+	if _, err := os.Stat(names[0]); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed", names[0])
+	}
+	for _, name := range names[1:] {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("%s should have been left alone - %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package dailylogger
+
+import (
+	"fmt"
+	"time"
+)
+
+// RotationPolicy controls when a Writer rotates its log and what the log files
+// are called.  Time is divided into periods.  Each period has its own log file,
+// named by a stamp that's derived from the start of the period.  Optionally, a
+// file can also be limited in size.  When a write would take a file past that
+// size, the Writer starts a new file with the same stamp and a sequence number,
+// for example "daily.2020-02-14.1.log".
+//
+// The policies supplied are Daily (the default), Hourly, EveryNMinutes, Weekly
+// and MaxSize, which adds a size limit to any of the others.
+type RotationPolicy interface {
+	// PeriodStart returns the start of the period that contains the time t.
+	PeriodStart(t time.Time) time.Time
+	// NextPeriodStart returns the start of the period after the one that
+	// starts at the given time.
+	NextPeriodStart(start time.Time) time.Time
+	// Stamp returns the part of the log file name that identifies the period
+	// that starts at the given time, for example "2020-02-14".
+	Stamp(start time.Time) string
+	// MaxSize returns the size in bytes at which a log file is rotated, or
+	// zero if the size is unlimited.
+	MaxSize() int64
+}
+
+// dailyPolicy rotates the log at midnight.
+type dailyPolicy struct{}
+
+// Daily returns a policy that rotates the log at midnight local time.  The stamp
+// is the date in yyyy-mm-dd format, for example "daily.2020-02-14.log".
+func Daily() RotationPolicy {
+	return dailyPolicy{}
+}
+
+// PeriodStart satisfies the RotationPolicy interface.
+func (p dailyPolicy) PeriodStart(t time.Time) time.Time {
+	return getLastMidnight(t)
+}
+
+// NextPeriodStart satisfies the RotationPolicy interface.
+func (p dailyPolicy) NextPeriodStart(start time.Time) time.Time {
+	return getNextMidnight(start)
+}
+
+// Stamp satisfies the RotationPolicy interface.
+func (p dailyPolicy) Stamp(start time.Time) string {
+	return fmt.Sprintf("%04d-%02d-%02d", start.Year(), int(start.Month()), start.Day())
+}
+
+// MaxSize satisfies the RotationPolicy interface.
+func (p dailyPolicy) MaxSize() int64 {
+	return 0
+}
+
+// minutePolicy rotates the log every N minutes.
+type minutePolicy struct {
+	minutes int
+}
+
+// Hourly returns a policy that rotates the log at the start of each hour.  The
+// stamp is the date and hour in yyyy-mm-dd-hh format, for example
+// "daily.2020-02-14-15.log".
+func Hourly() RotationPolicy {
+	return hourlyPolicy{minutePolicy{60}}
+}
+
+// EveryNMinutes returns a policy that rotates the log every n minutes.  The
+// periods are counted from midnight local time, so with n = 15 the log rotates
+// at 00:00, 00:15, 00:30 and so on.  If n doesn't divide the day exactly, the
+// last period of the day is cut short at midnight.  The stamp is the date and
+// time in yyyy-mm-dd-hhmm format, for example "daily.2020-02-14-1545.log".
+// EveryNMinutes panics if n is not positive.
+func EveryNMinutes(n int) RotationPolicy {
+	if n <= 0 {
+		panic(fmt.Sprintf("dailylogger: invalid rotation period of %d minutes", n))
+	}
+	return minutePolicy{n}
+}
+
+// PeriodStart satisfies the RotationPolicy interface.
+func (p minutePolicy) PeriodStart(t time.Time) time.Time {
+	midnight := getLastMidnight(t)
+	minutesSinceMidnight := t.Hour()*60 + t.Minute()
+	m := minutesSinceMidnight - minutesSinceMidnight%p.minutes
+	return time.Date(midnight.Year(), midnight.Month(), midnight.Day(), m/60, m%60, 0, 0, t.Location())
+}
+
+// NextPeriodStart satisfies the RotationPolicy interface.
+func (p minutePolicy) NextPeriodStart(start time.Time) time.Time {
+	next := start.Add(time.Duration(p.minutes) * time.Minute)
+	nextMidnight := getNextMidnight(start)
+	if next.After(nextMidnight) {
+		return nextMidnight
+	}
+	return next
+}
+
+// Stamp satisfies the RotationPolicy interface.
+func (p minutePolicy) Stamp(start time.Time) string {
+	return fmt.Sprintf("%04d-%02d-%02d-%02d%02d",
+		start.Year(), int(start.Month()), start.Day(), start.Hour(), start.Minute())
+}
+
+// MaxSize satisfies the RotationPolicy interface.
+func (p minutePolicy) MaxSize() int64 {
+	return 0
+}
+
+// hourlyPolicy is a minutePolicy with a shorter stamp.
+type hourlyPolicy struct {
+	minutePolicy
+}
+
+// Stamp satisfies the RotationPolicy interface.
+func (p hourlyPolicy) Stamp(start time.Time) string {
+	return fmt.Sprintf("%04d-%02d-%02d-%02d",
+		start.Year(), int(start.Month()), start.Day(), start.Hour())
+}
+
+// weeklyPolicy rotates the log at midnight at the start of each Monday.
+type weeklyPolicy struct{}
+
+// Weekly returns a policy that rotates the log at midnight local time at the
+// start of each Monday.  The stamp is the ISO 8601 year and week number, for
+// example "daily.2020-W07.log".
+func Weekly() RotationPolicy {
+	return weeklyPolicy{}
+}
+
+// PeriodStart satisfies the RotationPolicy interface.
+func (p weeklyPolicy) PeriodStart(t time.Time) time.Time {
+	// Go numbers the days from Sunday = 0.  Count from Monday instead.
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	return getLastMidnight(t.AddDate(0, 0, -daysSinceMonday))
+}
+
+// NextPeriodStart satisfies the RotationPolicy interface.
+func (p weeklyPolicy) NextPeriodStart(start time.Time) time.Time {
+	nextWeek := start.AddDate(0, 0, 7)
+	return time.Date(nextWeek.Year(), nextWeek.Month(), nextWeek.Day(), 0, 0, 0, 0, start.Location())
+}
+
+// Stamp satisfies the RotationPolicy interface.
+func (p weeklyPolicy) Stamp(start time.Time) string {
+	year, week := start.ISOWeek()
+	return fmt.Sprintf("%04d-W%02d", year, week)
+}
+
+// MaxSize satisfies the RotationPolicy interface.
+func (p weeklyPolicy) MaxSize() int64 {
+	return 0
+}
+
+// sizePolicy adds a size limit to another policy.
+type sizePolicy struct {
+	RotationPolicy
+	maxSize int64
+}
+
+// MaxSize returns a policy that rotates the log when a file would exceed the
+// given number of bytes, and also whenever the given policy would rotate it,
+// whichever comes first.  For example MaxSize(500*1024*1024, Daily()) rotates
+// the log daily or at 500 MB.  If the policy is nil, MaxSize uses Daily.  A
+// single Write that's larger than the limit goes into a file of its own.
+func MaxSize(bytes int64, policy RotationPolicy) RotationPolicy {
+	if policy == nil {
+		policy = Daily()
+	}
+	return sizePolicy{policy, bytes}
+}
+
+// MaxSize satisfies the RotationPolicy interface.
+func (p sizePolicy) MaxSize() int64 {
+	return p.maxSize
+}
@@ -0,0 +1,130 @@
+package dailylogger
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+	ts "github.com/goblimey/go-tools/testsupport"
+)
+
+// TestPolicies checks the periods and stamps produced by the rotation policies.
+func TestPolicies(t *testing.T) {
+	locationParis, _ := time.LoadLocation("Europe/Paris")
+	// Friday 14th February 2020, in ISO week 7.
+	now := time.Date(2020, time.February, 14, 15, 47, 3, 4, locationParis)
+
+	var testData = []struct {
+		description       string
+		policy            RotationPolicy
+		expectedStart     time.Time
+		expectedNextStart time.Time
+		expectedStamp     string
+	}{
+		{"daily", Daily(),
+			time.Date(2020, time.February, 14, 0, 0, 0, 0, locationParis),
+			time.Date(2020, time.February, 15, 0, 0, 0, 0, locationParis),
+			"2020-02-14"},
+		{"hourly", Hourly(),
+			time.Date(2020, time.February, 14, 15, 0, 0, 0, locationParis),
+			time.Date(2020, time.February, 14, 16, 0, 0, 0, locationParis),
+			"2020-02-14-15"},
+		{"15 minutes", EveryNMinutes(15),
+			time.Date(2020, time.February, 14, 15, 45, 0, 0, locationParis),
+			time.Date(2020, time.February, 14, 16, 0, 0, 0, locationParis),
+			"2020-02-14-1545"},
+		{"weekly", Weekly(),
+			time.Date(2020, time.February, 10, 0, 0, 0, 0, locationParis),
+			time.Date(2020, time.February, 17, 0, 0, 0, 0, locationParis),
+			"2020-W07"},
+		{"daily or size", MaxSize(1024, Daily()),
+			time.Date(2020, time.February, 14, 0, 0, 0, 0, locationParis),
+			time.Date(2020, time.February, 15, 0, 0, 0, 0, locationParis),
+			"2020-02-14"},
+	}
+
+	for _, td := range testData {
+		start := td.policy.PeriodStart(now)
+		if !start.Equal(td.expectedStart) {
+			t.Errorf("%s: expected period start %v, got %v", td.description, td.expectedStart, start)
+		}
+		nextStart := td.policy.NextPeriodStart(start)
+		if !nextStart.Equal(td.expectedNextStart) {
+			t.Errorf("%s: expected next period start %v, got %v", td.description, td.expectedNextStart, nextStart)
+		}
+		stamp := td.policy.Stamp(start)
+		if stamp != td.expectedStamp {
+			t.Errorf("%s: expected stamp \"%s\", got \"%s\"", td.description, td.expectedStamp, stamp)
+		}
+	}
+
+	// A period that doesn't divide the day is cut short at midnight.
+	policy := EveryNMinutes(7 * 60)
+	start := policy.PeriodStart(time.Date(2020, time.February, 14, 22, 0, 0, 0, locationParis))
+	expectedStart := time.Date(2020, time.February, 14, 21, 0, 0, 0, locationParis)
+	if !start.Equal(expectedStart) {
+		t.Errorf("7 hours: expected period start %v, got %v", expectedStart, start)
+	}
+	nextStart := policy.NextPeriodStart(start)
+	expectedNextStart := time.Date(2020, time.February, 15, 0, 0, 0, 0, locationParis)
+	if !nextStart.Equal(expectedNextStart) {
+		t.Errorf("7 hours: expected next period start %v, got %v", expectedNextStart, nextStart)
+	}
+}
+
+// TestRotateOnSize checks that a size-limited policy starts a new numbered file
+// when a write would take the current one over the limit, and that on restart
+// the Writer continues the last file in the sequence.
+func TestRotateOnSize(t *testing.T) {
+
+	// This test uses the filestore.
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	locationUTC, _ := time.LoadLocation("UTC")
+	stoppedClock := clock.NewStoppedClock(2020, time.February, 14, 1, 2, 3, 4, locationUTC)
+	policy := MaxSize(10, Daily())
+
+	writer := newPolicyWriter(stoppedClock, policy, ".", "foo.", ".bar")
+	writer.Write([]byte("012345"))
+	writer.Write([]byte("6789")) // Exactly fills the first file.
+	writer.Write([]byte("abc"))  // Starts foo.2020-02-14.1.bar.
+	writer.Close()
+
+	// Restart.  The writer should append to foo.2020-02-14.1.bar.
+	writer = newPolicyWriter(stoppedClock, policy, ".", "foo.", ".bar")
+	writer.Write([]byte("def"))
+	writer.Write([]byte("ghijk")) // Starts foo.2020-02-14.2.bar.
+	writer.Close()
+
+	expectedContents := map[string]string{
+		"foo.2020-02-14.bar":   "0123456789",
+		"foo.2020-02-14.1.bar": "abcdef",
+		"foo.2020-02-14.2.bar": "ghijk",
+	}
+
+	files, err := ioutil.ReadDir(directoryName)
+	if err != nil {
+		t.Fatalf("error scanning directory %s - %s", directoryName, err.Error())
+	}
+	if len(files) != len(expectedContents) {
+		t.Fatalf("directory %s contains %d files.  Should contain %d.",
+			directoryName, len(files), len(expectedContents))
+	}
+
+	for name, expected := range expectedContents {
+		contents, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Errorf("error reading logfile %s back - %v", name, err)
+			continue
+		}
+		if string(contents) != expected {
+			t.Errorf("logfile %s contains \"%s\" - expected \"%s\"", name, string(contents), expected)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package dailylogger
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Retention defines the rules for removing old log files.  A zero value
+// in any field means that the rule is not applied.  A file is removed if
+// any of the rules says so.
+//
+// The janitor only considers files in the log directory whose names match the
+// Writer's own pattern - the leader, a stamp and the trailer - and it never
+// removes the current log file.  The age of a file is taken from its last
+// modification time.
+type Retention struct {
+	// KeepDays keeps the files that were last written today or on the
+	// previous KeepDays-1 days.  With a daily rotation policy, KeepDays = 7
+	// keeps the last seven days' logs.
+	KeepDays int
+	// MaxAge removes files that were last written more than MaxAge ago.
+	MaxAge time.Duration
+	// MaxBytes removes the oldest files until the total size of the
+	// remaining files, including the current one, is no more than MaxBytes.
+	MaxBytes int64
+}
+
+// startJanitor starts the janitor goroutine if it's not already running.
+func (dw *Writer) startJanitor() {
+	if dw.janitorRunning {
+		return
+	}
+	dw.janitorRunning = true
+	dw.background.Add(1)
+	go dw.janitor()
+}
+
+// janitor runs until Close is called.  Each time it's asked to, it compresses
+// any log files that the Writer has finished with and then applies the
+// retention rules.  It should be run in a goroutine.
+func (dw *Writer) janitor() {
+
+	defer dw.background.Done()
+
+	dw.removeTemporaryFiles()
+
+	for {
+		select {
+		case <-dw.stop:
+			return
+		case <-dw.cleanupRequests:
+			dw.compressOldLogs()
+			dw.enforceRetention()
+		}
+	}
+}
+
+// requestCleanup wakes the janitor, if there is one.  It doesn't block.  If
+// the janitor has a request pending, the new request is merged with it.
+func (dw *Writer) requestCleanup() {
+	select {
+	case dw.cleanupRequests <- struct{}{}:
+	default:
+	}
+}
+
+// enforceRetention removes the log files that the retention rules say should
+// go.  It's called by the janitor and by unit tests.
+func (dw *Writer) enforceRetention() {
+
+	dw.logMutex.Lock()
+	if dw.retention == nil {
+		dw.logMutex.Unlock()
+		return
+	}
+	retention := *dw.retention
+	now := dw.clock.Now()
+	dw.logMutex.Unlock()
+
+	files, err := ioutil.ReadDir(dw.logDir)
+	if err != nil {
+		log.Printf("enforceRetention: cannot scan log directory %s - %s\n",
+			dw.logDir, err.Error())
+		return
+	}
+
+	// Find the current file after scanning the directory.  If the Writer
+	// rotates in between, the file it has moved to is not in the list.
+	currentName := dw.currentLogName()
+
+	// Find the Writer's own log files, newest first.
+	pattern := dw.logFilePattern()
+	var logFiles []os.FileInfo
+	var totalBytes int64
+	for _, file := range files {
+		if !file.Mode().IsRegular() || !pattern.MatchString(file.Name()) {
+			continue
+		}
+		totalBytes += file.Size()
+		if file.Name() != currentName {
+			logFiles = append(logFiles, file)
+		}
+	}
+	sort.Slice(logFiles, func(i, j int) bool {
+		return logFiles[i].ModTime().After(logFiles[j].ModTime())
+	})
+
+	var keepDaysCutoff time.Time
+	if retention.KeepDays > 0 {
+		keepDaysCutoff = getLastMidnight(now).AddDate(0, 0, -(retention.KeepDays - 1))
+	}
+
+	// Apply the age rules, keeping a list of the files that survive.
+	var remaining []os.FileInfo
+	for _, file := range logFiles {
+		remove := false
+		switch {
+		case retention.KeepDays > 0 && file.ModTime().Before(keepDaysCutoff):
+			remove = true
+		case retention.MaxAge > 0 && now.Sub(file.ModTime()) > retention.MaxAge:
+			remove = true
+		}
+
+		if remove && dw.removeLogFile(file.Name()) {
+			totalBytes -= file.Size()
+		} else {
+			remaining = append(remaining, file)
+		}
+	}
+
+	if retention.MaxBytes <= 0 {
+		return
+	}
+
+	// Remove the oldest of the remaining files until the total is small
+	// enough.
+	for i := len(remaining) - 1; i >= 0 && totalBytes > retention.MaxBytes; i-- {
+		file := remaining[i]
+		if dw.removeLogFile(file.Name()) {
+			totalBytes -= file.Size()
+		}
+	}
+}
+
+// currentLogName returns the name of the file that the Writer is writing to.
+func (dw *Writer) currentLogName() string {
+	dw.logMutex.Lock()
+	defer dw.logMutex.Unlock()
+	return filepath.Base(dw.getLogPathname(dw.periodStart, dw.sequence))
+}
+
+// removeLogFile removes a file from the log directory.  It returns true if
+// the file has gone.
+func (dw *Writer) removeLogFile(name string) bool {
+	err := os.Remove(filepath.Join(dw.logDir, name))
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("enforceRetention: cannot remove old log file %s - %s\n",
+			name, err.Error())
+		return false
+	}
+	return true
+}
+
+// logFilePattern returns a regular expression that matches the names of the
+// Writer's log files - the leader, a stamp and the trailer, plus the suffix
+// of a compressed file.
+func (dw *Writer) logFilePattern() *regexp.Regexp {
+	// The stamps produced by the supplied policies start with the year and
+	// contain only digits, '-', 'W' and, for a numbered file, '.'.
+	return regexp.MustCompile("^" + regexp.QuoteMeta(dw.leader) +
+		"[0-9]{4}[-0-9W.]*" + regexp.QuoteMeta(dw.trailer) +
+		"(" + regexp.QuoteMeta(compressedSuffix) + ")?$")
+}
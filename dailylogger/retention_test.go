@@ -0,0 +1,140 @@
+package dailylogger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+	ts "github.com/goblimey/go-tools/testsupport"
+)
+
+// TestRetention checks that the retention rules remove the right files and
+// leave alone the current log file and any files that don't belong to the
+// Writer.
+func TestRetention(t *testing.T) {
+
+	// This test uses the filestore.
+
+	locationUTC, _ := time.LoadLocation("UTC")
+	now := time.Date(2020, time.February, 14, 12, 0, 0, 0, locationUTC)
+
+	var testData = []struct {
+		description   string
+		retention     Retention
+		expectedFiles []string
+	}{
+		{"keep days", Retention{KeepDays: 3},
+			[]string{"foo.2020-02-12.bar", "foo.2020-02-13.bar", "foo.2020-02-14.bar", "notes.txt"}},
+		{"max age", Retention{MaxAge: 36 * time.Hour},
+			[]string{"foo.2020-02-13.bar", "foo.2020-02-14.bar", "notes.txt"}},
+		{"max bytes", Retention{MaxBytes: 25},
+			[]string{"foo.2020-02-12.bar", "foo.2020-02-13.bar", "foo.2020-02-14.bar", "notes.txt"}},
+		{"no rules", Retention{},
+			[]string{"foo.2020-02-10.bar", "foo.2020-02-11.bar", "foo.2020-02-12.bar",
+				"foo.2020-02-13.bar", "foo.2020-02-14.bar", "notes.txt"}},
+	}
+
+	for _, td := range testData {
+		directoryName, err := ts.CreateWorkingDirectory()
+		if err != nil {
+			t.Fatalf("createWorkingDirectory failed - %v", err)
+		}
+
+		// Create a file for each of the last four days, each ten bytes long
+		// and last written at noon, plus a file that doesn't belong to the
+		// Writer.
+		for day := 10; day <= 13; day++ {
+			name := fmt.Sprintf("foo.2020-02-%02d.bar", day)
+			err = ioutil.WriteFile(name, []byte("0123456789"), 0644)
+			if err != nil {
+				t.Fatalf("%s: cannot create %s - %v", td.description, name, err)
+			}
+			modTime := time.Date(2020, time.February, day, 12, 0, 0, 0, locationUTC)
+			os.Chtimes(name, modTime, modTime)
+		}
+		err = ioutil.WriteFile("notes.txt", []byte("0123456789"), 0644)
+		if err != nil {
+			t.Fatalf("%s: cannot create notes.txt - %v", td.description, err)
+		}
+		old := time.Date(2019, time.January, 1, 0, 0, 0, 0, locationUTC)
+		os.Chtimes("notes.txt", old, old)
+
+		stoppedClock := clock.NewStoppedClock(2020, time.February, 14, 12, 0, 0, 0, locationUTC)
+		writer := newWriter(stoppedClock, ".", "foo.", ".bar")
+		writer.Write([]byte("01234"))
+		os.Chtimes("foo.2020-02-14.bar", now, now)
+		writer.retention = &td.retention
+
+		writer.enforceRetention()
+
+		writer.Close()
+
+		files, err := ioutil.ReadDir(directoryName)
+		if err != nil {
+			t.Fatalf("%s: error scanning directory %s - %s", td.description, directoryName, err.Error())
+		}
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		sort.Strings(names)
+
+		if strings.Join(names, " ") != strings.Join(td.expectedFiles, " ") {
+			t.Errorf("%s: expected files %v, got %v", td.description, td.expectedFiles, names)
+		}
+
+		ts.RemoveWorkingDirectory(directoryName)
+	}
+}
+
+// TestJanitorRunsOnRotation checks that the janitor removes old files after
+// the log rotates.
+func TestJanitorRunsOnRotation(t *testing.T) {
+
+	// This test uses the filestore.
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	locationUTC, _ := time.LoadLocation("UTC")
+	manualClock := clock.NewManualClock(
+		time.Date(2020, time.February, 14, 23, 59, 0, 0, locationUTC))
+	writer, err := NewWithClock(manualClock, "", "foo.", ".bar")
+	if err != nil {
+		t.Fatalf("NewWithClock failed - %v", err)
+	}
+	defer writer.Close()
+	writer.Write([]byte("hello"))
+
+	// Keep only the current file.
+	writer.SetRetention(Retention{MaxBytes: 1})
+
+	// Rotate the log and wait for the rotator to set its next timer.
+	manualClock.BlockUntil(1)
+	manualClock.Advance(2 * time.Minute)
+	manualClock.BlockUntil(1)
+
+	// The janitor runs in the background.  Give it a while to finish.
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat("foo.2020-02-14.bar"); os.IsNotExist(err) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	files, err := ioutil.ReadDir(directoryName)
+	if err != nil {
+		t.Fatalf("error scanning directory %s - %s", directoryName, err.Error())
+	}
+	if len(files) != 1 || files[0].Name() != "foo.2020-02-15.bar" {
+		t.Errorf("expected just foo.2020-02-15.bar, got %d files", len(files))
+	}
+}
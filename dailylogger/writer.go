@@ -3,8 +3,11 @@ package dailylogger
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,7 +22,10 @@ import (
 // "data" and trailer "log" would be "data.20201005.log".
 //
 // The Writer rolls the log over at midnight at the start of each day - it
-// closes yesterday's log and creates today's.
+// closes yesterday's log and creates today's.  NewWithPolicy takes a
+// RotationPolicy that can change that, for example to rotate the log hourly
+// or when the file reaches a given size.  Each policy has its own naming
+// scheme.  The default policy is Daily.
 //
 // On start up, the first call of New creates today's log file if it doesn't
 // already exist.  If the file has already been created, the Writer appends to
@@ -33,37 +39,112 @@ import (
 // controlled by a Clock object.  The New factory function creates a Clock which
 // is a thin wrapper around the standard time service.  That should be used by
 // production code.  Tests can use a clock object that supplies predefined values.
+// NewWithClock takes a TimerClock, which also drives the log rotator, so a test
+// or a simulation can use a ManualClock to rotate the logs without waiting.
+//
+// The log rotator runs in a goroutine until Close is called.  Close stops it and
+// closes the current log file.
 type Writer struct {
 	logMutex        sync.Mutex
 	loggingDisabled bool                 // True if logging is disable. (Logging is enabled by default.)
 	clock           clock.Clock          // The system clock in production, a fake in testing.
-	startOfToday    time.Time            // The current datestamp for the log.
+	policy          RotationPolicy       // Controls when the log is rotated.
+	periodStart     time.Time            // The start of the current rotation period.
+	sequence        int                  // The sequence number of the log file within the period.
+	size            int64                // The size of the current log file.
 	logDir          string               // The log directory.
 	leader          string               // The leading part of he log file name.
 	trailer         string               // The trailing part of the log file name.
 	switchwriter    *switchwriter.Writer // The connection to the log file.
+	degraded        bool                 // True if the current log file couldn't be opened.
+	lastOpenAttempt time.Time            // The time of the last attempt to open a log file.
+	timerClock      clock.TimerClock     // Drives the retries of a degraded Writer.  Nil if there are none.
+	retryTimer      clock.Timer          // Set while a retry of a degraded Writer is pending.
+	err             error                // The last I/O failure, nil if the Writer is healthy.
+	errTime         time.Time            // The time of the last I/O failure.
+	retention       *Retention           // The rules for removing old log files.  Nil if there are none.
+	compression     Compression          // The compression applied to old log files.
+	janitorRunning  bool                 // True if the janitor has been started.
+	cleanupRequests chan struct{}        // Wakes the janitor.
+	stop            chan struct{}        // Closed to stop the background goroutines.
+	background      sync.WaitGroup       // Tracks the background goroutines.
+	closeOnce       sync.Once            // Ensures that Close only does its work once.
+	closed          bool                 // True once Close has closed the log file.
 }
 
-// This is a compile-time check that Writer implements the io.Writer interface.
-var _ io.Writer = (*Writer)(nil)
+// This is a compile-time check that Writer implements the io.Writer and io.Closer interfaces.
+var (
+	_ io.Writer = (*Writer)(nil)
+	_ io.Closer = (*Writer)(nil)
+)
+
+// retryInterval is the time that a degraded Writer waits between attempts to
+// open its log file.
+const retryInterval = time.Minute
+
+// Health describes the state of a Writer.
+type Health struct {
+	// Degraded is true if the Writer couldn't open its current log file and
+	// is discarding writes until it can.
+	Degraded bool
+	// LastError is the last I/O failure since the current log file was
+	// opened, nil if there hasn't been one.
+	LastError error
+	// LastErrorTime is the time of the last I/O failure.
+	LastErrorTime time.Time
+}
 
 // New creates a Writer, starts the log rotator and returns the writer.  Production
-// code should call this to get a Writer.
-func New(logDir, leader, trailer string) *Writer {
+// code should call this to get a Writer.  If the log directory or the first log
+// file can't be created, New returns an error.
+func New(logDir, leader, trailer string) (*Writer, error) {
+	return NewWithClock(&clock.SystemClock{}, logDir, leader, trailer)
+}
+
+// NewWithClock creates a Writer whose notion of time and whose log rotator are
+// both driven by the given clock, starts the log rotator and returns the writer.
+func NewWithClock(cl clock.TimerClock, logDir, leader, trailer string) (*Writer, error) {
+	return NewWithPolicy(cl, Daily(), logDir, leader, trailer)
+}
+
+// NewWithPolicy creates a Writer that rotates the log according to the given
+// policy, starts the log rotator and returns the writer.  If the policy is nil,
+// the Writer rotates the log daily.  If the clock is nil, the Writer uses the
+// system clock.
+func NewWithPolicy(cl clock.TimerClock, policy RotationPolicy, logDir, leader, trailer string) (*Writer, error) {
+
+	if cl == nil {
+		cl = &clock.SystemClock{}
+	}
 
-	dw := newWriter(nil, logDir, leader, trailer)
+	dw := newPolicyWriter(cl, policy, logDir, leader, trailer)
+	if dw.degraded {
+		err := dw.err
+		dw.Close()
+		return nil, err
+	}
+
+	// A degraded Writer retries opening the log file on the clock.
+	dw.timerClock = cl
 
-	// Start a goroutine to roll the log over at the end of each day.
-	go dw.logRotator()
-	return dw
+	// Start a goroutine to roll the log over at the end of each period.
+	dw.background.Add(1)
+	go dw.logRotator(cl)
+	return dw, nil
 }
 
-// newWriter creates a daily writer with a supplied switchwriter and clock,
-// and returns a pointer to it. This is called by New as a helper method and by
-// unit tests.
+// newWriter creates a daily writer with a supplied clock, and returns a pointer
+// to it. This is used by unit tests.
 func newWriter(cl clock.Clock, logDir, leader, trailer string) *Writer {
+	return newPolicyWriter(cl, Daily(), logDir, leader, trailer)
+}
 
-	// The logfile is of the form "logDir/leader.yyyy-mm-dd.trailer".  The default
+// newPolicyWriter creates a writer with a supplied clock and rotation policy,
+// and returns a pointer to it. This is called by NewWithPolicy as a helper
+// method and by unit tests.
+func newPolicyWriter(cl clock.Clock, policy RotationPolicy, logDir, leader, trailer string) *Writer {
+
+	// The logfile is of the form "logDir/leader.stamp.trailer".  The default
 	// is "./daily.yyyy-mm-dd.log".
 	const defaultLeader = "daily."
 	const defaultTrailer = ".log"
@@ -86,23 +167,62 @@ func newWriter(cl clock.Clock, logDir, leader, trailer string) *Writer {
 		cl = clock.NewSystemClock()
 	}
 
-	sw := switchwriter.New()
+	if policy == nil {
+		policy = Daily()
+	}
 
-	dw := Writer{clock: cl, switchwriter: sw,
-		logDir: logDir, leader: leader, trailer: trailer}
+	// The switchwriter closes each log file when it switches away from it.
+	sw := switchwriter.NewOwner()
 
-	// Create the log directory if it doesn't already exist.
-	createlogDirectory(logDir)
+	dw := Writer{clock: cl, policy: policy, switchwriter: sw,
+		logDir: logDir, leader: leader, trailer: trailer,
+		cleanupRequests: make(chan struct{}, 1), stop: make(chan struct{})}
 
-	// Create today's log file and switch the switchwriter to it.
-	dw.startOfToday = getLastMidnight(cl.Now())
-	dw.openLog(dw.startOfToday)
+	// Create the log file for the current period and switch the switchwriter
+	// to it.
+	dw.periodStart = policy.PeriodStart(cl.Now())
+	dw.openLog(dw.periodStart)
 
 	return &dw
 }
 
+// SetRetention sets the rules for removing old log files and starts a janitor
+// goroutine that applies them after each rotation.  See Retention.
+func (dw *Writer) SetRetention(retention Retention) {
+	dw.logMutex.Lock()
+	dw.retention = &retention
+	dw.startJanitor()
+	dw.logMutex.Unlock()
+
+	// Apply the new rules straight away.
+	dw.requestCleanup()
+}
+
+// SetCompression sets the compression applied to log files that the Writer
+// has finished with, and starts a janitor goroutine that compresses them after
+// each rotation.  The janitor also compresses any files left uncompressed by
+// an earlier run.  See Compression.
+func (dw *Writer) SetCompression(compression Compression) {
+	dw.logMutex.Lock()
+	dw.compression = compression
+	dw.startJanitor()
+	dw.logMutex.Unlock()
+
+	// Compress any leftover files straight away.
+	dw.requestCleanup()
+}
+
 // Write writes the buffer to the daily log file, creating the file at the
-// start of each day.
+// start of each day.  If the rotation policy limits the size of the file and
+// the buffer would take the file over the limit, Write first rotates the log.
+//
+// If the Writer is in a degraded state because the log file couldn't be
+// opened, Write discards the buffer but pretends that it was written, as a
+// disabled switchwriter does.  It retries the open once every retryInterval,
+// and so does a timer set when the open fails, so the Writer recovers even
+// if nothing is written.
+//
+// After Close, Write returns os.ErrClosed.
 func (dw *Writer) Write(buffer []byte) (int, error) {
 	if dw.loggingDisabled {
 		return 0, nil
@@ -111,8 +231,23 @@ func (dw *Writer) Write(buffer []byte) (int, error) {
 		dw.logMutex.Lock()
 		defer dw.logMutex.Unlock()
 
+		if dw.closed {
+			return 0, os.ErrClosed
+		}
+
+		dw.retryOpen()
+
+		maxSize := dw.policy.MaxSize()
+		if maxSize > 0 && dw.size > 0 && dw.size+int64(len(buffer)) > maxSize {
+			dw.rotateForSize()
+		}
+
 		// Write to the log.
 		n, err := dw.switchwriter.Write(buffer)
+		dw.size += int64(n)
+		if err != nil {
+			dw.recordFailure(err)
+		}
 		return n, err
 	}
 
@@ -138,113 +273,308 @@ func (dw *Writer) GetTimestampForLog() string {
 		now.Location().String())
 }
 
+// Err returns the last I/O failure since the current log file was opened, or
+// nil if there hasn't been one.  Once the Writer has failed to open a log file,
+// it runs in a degraded state, discarding writes and retrying the open, until
+// the open succeeds.
+func (dw *Writer) Err() error {
+	dw.logMutex.Lock()
+	defer dw.logMutex.Unlock()
+	return dw.err
+}
+
+// Health returns the current state of the Writer.
+func (dw *Writer) Health() Health {
+	dw.logMutex.Lock()
+	defer dw.logMutex.Unlock()
+	return Health{Degraded: dw.degraded, LastError: dw.err, LastErrorTime: dw.errTime}
+}
+
+// recordFailure records an I/O failure.
+func (dw *Writer) recordFailure(err error) {
+	dw.err = err
+	dw.errTime = dw.clock.Now()
+}
+
 // setClock sets the clock.  This is used for unit testing.
 func (dw *Writer) setClock(clock clock.Clock) {
 	dw.clock = clock
 }
 
-// rotator() runs forever, rotating the log files at the end of each day.
-func (dw *Writer) logRotator() {
+// Close stops the log rotator, the janitor and any pending retry and closes the
+// current log file.  Any subsequent Write returns os.ErrClosed.  Close can be
+// called more than once.
+func (dw *Writer) Close() error {
+	var err error
+	dw.closeOnce.Do(func() {
+		// Stop the background goroutines and wait for them to finish any
+		// work that they have started.
+		close(dw.stop)
+		dw.background.Wait()
+
+		dw.logMutex.Lock()
+		defer dw.logMutex.Unlock()
+		dw.closed = true
+		if dw.retryTimer != nil {
+			dw.retryTimer.Stop()
+			dw.retryTimer = nil
+		}
+		err = dw.closeLog()
+	})
+	return err
+}
+
+// logRotator runs until Close is called, rotating the log files at the end of
+// each period.  It should be run in a goroutine.
+func (dw *Writer) logRotator(cl clock.TimerClock) {
 
-	// This should be run in a goroutine.
-	//
-	// As it runs forever it can't be unit tested.  It uses the real time,
-	// not the supplied clock, which is for testing.
+	defer dw.background.Done()
 
 	for {
-		// Sleep until the end of day
-		now := time.Now()
-		waitTime := getDurationToMidnight(now)
-		time.Sleep(waitTime)
+		// Sleep until the end of the period.
+		dw.logMutex.Lock()
+		nextPeriodStart := dw.policy.NextPeriodStart(dw.periodStart)
+		dw.logMutex.Unlock()
+		waitTime := nextPeriodStart.Sub(cl.Now())
+		timer := cl.NewTimer(waitTime)
+
+		select {
+		case <-dw.stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
 
 		// Wake up and rotate the log file using the next
-		// day as the timestamp.
+		// period as the timestamp.
 		//
 		// If the system is running properly, It could by now
-		// be a fraction of a second before midnight or (more
+		// be a fraction of a second before the end of the
+		// period (for a daily log, midnight) or (more
 		// likely) a fraction of a second after.  If the system
 		// gets very slow for some reason, it could be much
 		// later than that.  In the very worst case, a later
-		// day altogether, but that's *very* unlikely.
+		// period altogether, but that's *very* unlikely.
 		dw.rotateLogs()
 	}
 }
 
-// rotateLogs() rotates the daily log files.
+// rotateLogs() rotates the log files at the end of a period.
 func (dw *Writer) rotateLogs() {
 	// Avoid a race with Write.
 	dw.logMutex.Lock()
 	defer dw.logMutex.Unlock()
 	currentTime := dw.clock.Now()
-	dw.closeLog()
-
-	// Advance the current day.
-	// This should be happening just before or just after midnight
-	// so the start of today should be the same as the stored
-	// start of day or (more likely) that time plus one day.  If
-	// the goroutine has drifted, start of today may be yet later
-	// but this sequence will fix that.
-	startOfToday := getLastMidnight(currentTime)
-
-	if startOfToday.After(dw.startOfToday) {
-		// Example: the stored start of day is midnight on the 1st.
-		// It's now some day after that, probably the 2nd, so the
-		// stored start of day should be 00:00:00 on the 2nd - the
+	err := dw.closeLog()
+	if err != nil {
+		log.Printf("rotateLogs: error closing log file - %s\n", err.Error())
+		// Continue - the new log file can still be opened.
+	}
+
+	// Advance the current period.
+	// This should be happening just before or just after the
+	// end of the period so the calculated start of the period
+	// should be the same as the stored start or (more likely)
+	// the start of the next period.  If the goroutine has
+	// drifted, the start of the period may be yet later but
+	// this sequence will fix that.
+	periodStart := dw.policy.PeriodStart(currentTime)
+
+	if periodStart.After(dw.periodStart) {
+		// Example: the log is rotated daily and the stored
+		// start of period is midnight on the 1st.  It's now
+		// some day after that, probably the 2nd, so the stored
+		// start of period should be 00:00:00 on the 2nd - the
 		// last midnight.
-		dw.startOfToday = getLastMidnight(startOfToday)
+		dw.periodStart = periodStart
 	} else {
-		// Example, the stored start of day is the 1st.  We are
-		// running just before midnight so the calculated start
-		// of day is also the 1st, not the 2nd.  The stored start
-		// of day should be 00:00:00 on the 2nd - the next midnight.
-		dw.startOfToday = getNextMidnight(startOfToday)
+		// Example, the stored start of period is the 1st.  We
+		// are running just before midnight so the calculated
+		// start of period is also the 1st, not the 2nd.  The
+		// stored start of period should be 00:00:00 on the 2nd
+		// - the next midnight.
+		dw.periodStart = dw.policy.NextPeriodStart(dw.periodStart)
+	}
+
+	// Open the logfile using start of period as the timestamp.
+
+	dw.openLog(dw.periodStart)
+
+	// Remove any old log files.
+	dw.requestCleanup()
+}
+
+// retryOpen tries again to open the log file if the Writer is degraded and
+// retryInterval has passed since the last attempt.
+func (dw *Writer) retryOpen() {
+	if dw.degraded && dw.clock.Now().Sub(dw.lastOpenAttempt) >= retryInterval {
+		dw.openLogFile(dw.sequence)
 	}
+}
 
-	// Open the logfile using start of today as the timestamp.
+// scheduleRetry sets a timer to retry opening the log file after
+// retryInterval, so that a degraded Writer recovers even if nothing is
+// written.  A Writer without a TimerClock relies on Write to retry.
+func (dw *Writer) scheduleRetry() {
+	if dw.timerClock == nil || dw.retryTimer != nil || dw.closed {
+		return
+	}
+	dw.retryTimer = dw.timerClock.AfterFunc(retryInterval, func() {
+		dw.logMutex.Lock()
+		defer dw.logMutex.Unlock()
+		dw.retryTimer = nil
+		if !dw.closed {
+			// If this attempt fails too, it sets another timer.
+			dw.retryOpen()
+		}
+	})
+}
 
-	dw.openLog(dw.startOfToday)
+// rotateForSize closes the current log file and opens the next one in the
+// sequence for the current period.
+func (dw *Writer) rotateForSize() {
+	err := dw.closeLog()
+	if err != nil {
+		log.Printf("rotateForSize: error closing log file - %s\n", err.Error())
+		// Continue - the new log file can still be opened.
+	}
+	dw.openLogFile(dw.sequence + 1)
+
+	// Remove any old log files.
+	dw.requestCleanup()
 }
 
 // CreateLogDirectory creates the log directory if it does not
 // already exist.
-func createlogDirectory(directory string) {
+func createlogDirectory(directory string) error {
 	err := os.MkdirAll(directory, os.ModePerm)
 	if err != nil {
-		log.Fatalf("cannot create log directory " + directory + " - " + err.Error())
+		return fmt.Errorf("cannot create log directory %s - %v", directory, err)
 	}
+	return nil
 }
 
-// Create the log directory if it doesn't already exist.
-
 // closeLog is a helper function that closes the log file (which
 // also flushes any uncommitted writes).  It doesn't apply the
 // lock so it should only be called by a function that does.
-func (dw *Writer) closeLog() {
-	dw.switchwriter.SwitchTo(nil)
+func (dw *Writer) closeLog() error {
+	dw.size = 0
+	_, err := dw.switchwriter.SwitchTo(nil)
+	return err
+}
+
+// openLog is a helper function that opens the log for the period that
+// starts at the given time.  If the policy limits the size of the file,
+// openLog continues the last file of any existing sequence for the
+// period, or starts the next file if that one is full.  It doesn't apply
+// the lock, so it should only be done by something that does.
+func (dw *Writer) openLog(periodStart time.Time) {
+	dw.periodStart = periodStart
+
+	sequence := 0
+	if dw.policy.MaxSize() > 0 {
+		sequence = dw.lastSequence(periodStart)
+	}
+
+	// A file that has already been compressed can't be appended to.  Start
+	// the next one in the sequence.
+	for dw.isCompressed(periodStart, sequence) {
+		sequence++
+	}
+
+	dw.openLogFile(sequence)
+
+	if dw.policy.MaxSize() > 0 && dw.size >= dw.policy.MaxSize() {
+		dw.closeLog()
+		dw.openLogFile(sequence + 1)
+	}
 }
 
-// openLog is a helper function that opens today's log.  It doesn't
-// apply the lock, so it should only be done by something that does.
-func (dw *Writer) openLog(startOfToday time.Time) {
+// openLogFile is a helper function that opens the log file with the given
+// sequence number in the current period and switches the switchwriter to
+// it.  It doesn't apply the lock, so it should only be done by something
+// that does.
+func (dw *Writer) openLogFile(sequence int) {
+	dw.sequence = sequence
+	dw.size = 0
+	dw.lastOpenAttempt = dw.clock.Now()
 
-	// Create the log directory
-	pathname := dw.getLogPathname(startOfToday)
+	// Create the log directory if it doesn't already exist.
+	err := createlogDirectory(dw.logDir)
+	if err != nil {
+		dw.recordFailure(err)
+		dw.degraded = true
+		dw.scheduleRetry()
+		return
+	}
+
+	pathname := dw.getLogPathname(dw.periodStart, sequence)
 
 	logFile, err := openFile(pathname)
 	if err != nil {
 		log.Printf("openLog: error creating log file %s - %s\n",
 			pathname, err.Error())
-		// Continue - file is now nil.
+		// Continue in a degraded state - log writes will be discarded
+		// until a later attempt to open the file succeeds.
+		dw.recordFailure(err)
+		dw.degraded = true
+		dw.scheduleRetry()
+		return
+	}
+
+	info, err := logFile.Stat()
+	if err == nil {
+		dw.size = info.Size()
 	}
+
 	dw.switchwriter.SwitchTo(logFile)
+	dw.degraded = false
+	dw.err = nil
 }
 
-// getLogPathname returns today's log filename, for example "data.2020-01-19.rtcm3".
-// The time is supplied to aid unit testing.
-func (dw *Writer) getLogPathname(now time.Time) string {
+// getLogPathname returns the log filename for the period that starts at the
+// given time, for example "data.2020-01-19.rtcm3".  The first file in the
+// period has no sequence number.  If the policy limits the size of the file
+// the next is "data.2020-01-19.1.rtcm3" and so on.  The time is supplied to
+// aid unit testing.
+func (dw *Writer) getLogPathname(periodStart time.Time, sequence int) string {
+
+	if sequence == 0 {
+		return fmt.Sprintf("%s/%s%s%s",
+			dw.logDir, dw.leader, dw.policy.Stamp(periodStart), dw.trailer)
+	}
+
+	return fmt.Sprintf("%s/%s%s.%d%s",
+		dw.logDir, dw.leader, dw.policy.Stamp(periodStart), sequence, dw.trailer)
+}
+
+// lastSequence returns the highest sequence number of the existing log files,
+// compressed or not, for the period that starts at the given time, or zero if
+// there are none.
+func (dw *Writer) lastSequence(periodStart time.Time) int {
+	files, err := ioutil.ReadDir(dw.logDir)
+	if err != nil {
+		return 0
+	}
+
+	prefix := dw.leader + dw.policy.Stamp(periodStart) + "."
+	last := 0
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), compressedSuffix)
+		if len(name) <= len(prefix)+len(dw.trailer) ||
+			!strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, dw.trailer) {
+			continue
+		}
+		sequence, err := strconv.Atoi(name[len(prefix) : len(name)-len(dw.trailer)])
+		if err != nil || sequence <= 0 {
+			continue
+		}
+		if sequence > last {
+			last = sequence
+		}
+	}
 
-	return fmt.Sprintf("%s/%s%04d-%02d-%02d%s",
-		dw.logDir, dw.leader, now.Year(), int(now.Month()), now.Day(), dw.trailer)
+	return last
 }
 
 // openFile either creates and opens the file or, if it already exists, opens it
@@ -252,11 +582,12 @@ func (dw *Writer) getLogPathname(now time.Time) string {
 func openFile(name string) (*os.File, error) {
 	file, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	_, err = file.Seek(0, 2)
 	if err != nil {
-		log.Fatal(err)
+		file.Close()
+		return nil, err
 	}
 	return file, nil
 }
@@ -27,7 +27,11 @@ func TestDailyLoggerIntegration(t *testing.T) {
 	}
 	defer ts.RemoveWorkingDirectory(directoryName)
 
-	writer := New(".", "", "")
+	writer, err := New(".", "", "")
+	if err != nil {
+		t.Fatalf("New failed - %v", err)
+	}
+	defer writer.Close()
 
 	expectedFilenamePattern := "daily.[0-9][0-9][0-9][0-9]-[0-9][0-9]-[0-9][0-9].log"
 
@@ -499,3 +499,247 @@ func TestAppendOnRestart(t *testing.T) {
 		t.Fatalf("logfile contains \"%s\" - expected \"%s\"", contents, expectedFinalContents)
 	}
 }
+
+// TestRotatorDrivenByClock checks that the log rotator started by NewWithClock
+// uses the supplied clock, so advancing a ManualClock past midnight rotates
+// the log, and that Close stops the rotator.
+func TestRotatorDrivenByClock(t *testing.T) {
+
+	// This test uses the filestore.
+
+	const expectedMessage1 = "hello"
+	const expectedFilename1 = "foo.2020-02-14.bar"
+	const expectedMessage2 = "world"
+	const expectedFilename2 = "foo.2020-02-15.bar"
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	locationParis, _ := time.LoadLocation("Europe/Paris")
+	manualClock := clock.NewManualClock(
+		time.Date(2020, time.February, 14, 23, 59, 0, 0, locationParis))
+	writer, err := NewWithClock(manualClock, "", "foo.", ".bar")
+	if err != nil {
+		t.Fatalf("NewWithClock failed - %v", err)
+	}
+
+	// Wait for the rotator to set its timer.
+	manualClock.BlockUntil(1)
+
+	_, err = writer.Write([]byte(expectedMessage1))
+	if err != nil {
+		t.Fatalf("Write failed - %v", err)
+	}
+
+	// Move the time past midnight and wait for the rotator to set its
+	// timer for the following midnight, which it does after rotating.
+	manualClock.Advance(2 * time.Minute)
+	manualClock.BlockUntil(1)
+
+	_, err = writer.Write([]byte(expectedMessage2))
+	if err != nil {
+		t.Fatalf("Write failed - %v", err)
+	}
+
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("Close failed - %v", err)
+	}
+
+	if manualClock.WaiterCount() != 0 {
+		t.Errorf("after Close the rotator still has %d timers set", manualClock.WaiterCount())
+	}
+
+	// Writes after Close fail and don't reopen the log.
+	n, err := writer.Write([]byte("discarded"))
+	if n != 0 || err != os.ErrClosed {
+		t.Fatalf("Write after Close returned %d, %v - expected 0, %v", n, err, os.ErrClosed)
+	}
+
+	// Close can be called again.
+	err = writer.Close()
+	if err != nil {
+		t.Fatalf("second Close failed - %v", err)
+	}
+
+	contents1, err := ioutil.ReadFile(expectedFilename1)
+	if err != nil {
+		t.Fatalf("error reading logfile %s back - %v", expectedFilename1, err)
+	}
+	if string(contents1) != expectedMessage1 {
+		t.Errorf("logfile %s contains \"%s\" - expected \"%s\"",
+			expectedFilename1, string(contents1), expectedMessage1)
+	}
+
+	contents2, err := ioutil.ReadFile(expectedFilename2)
+	if err != nil {
+		t.Fatalf("error reading logfile %s back - %v", expectedFilename2, err)
+	}
+	if string(contents2) != expectedMessage2 {
+		t.Errorf("logfile %s contains \"%s\" - expected \"%s\"",
+			expectedFilename2, string(contents2), expectedMessage2)
+	}
+}
+
+// TestRotatorSleepsUntilPeriodEnd checks that a healthy Writer's log rotator
+// sleeps until the end of the period, so advancing a ManualClock across the
+// period boundary in one step rotates the log.
+func TestRotatorSleepsUntilPeriodEnd(t *testing.T) {
+
+	// This test uses the filestore.
+
+	const expectedFilename = "foo.2020-02-15.bar"
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	locationUTC, _ := time.LoadLocation("UTC")
+	manualClock := clock.NewManualClock(
+		time.Date(2020, time.February, 14, 12, 0, 0, 0, locationUTC))
+	writer, err := NewWithClock(manualClock, "", "foo.", ".bar")
+	if err != nil {
+		t.Fatalf("NewWithClock failed - %v", err)
+	}
+	defer writer.Close()
+
+	// Advance past midnight in one step and wait for the rotator to set its
+	// timer for the following midnight, which it does after rotating.
+	manualClock.BlockUntil(1)
+	manualClock.Advance(13 * time.Hour)
+	manualClock.BlockUntil(1)
+
+	if _, err := os.Stat(expectedFilename); err != nil {
+		t.Errorf("expected the log to be rotated to %s - %v", expectedFilename, err)
+	}
+}
+
+// TestDegradedWriter checks that a Writer that can't open its log file reports
+// the failure, discards writes and retries the open later.
+func TestDegradedWriter(t *testing.T) {
+
+	// This test uses the filestore.
+
+	const expectedMessage = "hello"
+	const expectedPathName = "blocker/daily.2020-02-14.log"
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	// A plain file where the log directory should be prevents the log
+	// directory from being created.
+	err = ioutil.WriteFile("blocker", []byte("x"), 0644)
+	if err != nil {
+		t.Fatalf("cannot create blocking file - %v", err)
+	}
+
+	locationUTC, _ := time.LoadLocation("UTC")
+	manualClock := clock.NewManualClock(
+		time.Date(2020, time.February, 14, 12, 0, 0, 0, locationUTC))
+
+	// The production constructor fails.
+	writer, err := NewWithClock(manualClock, "blocker", "", "")
+	if err == nil {
+		t.Fatal("expected NewWithClock to fail")
+	}
+	if writer != nil {
+		t.Fatal("expected NewWithClock to return a nil writer")
+	}
+
+	// The helper returns a degraded writer.
+	writer = newWriter(manualClock, "blocker", "", "")
+	defer writer.Close()
+
+	health := writer.Health()
+	if !health.Degraded || health.LastError == nil || writer.Err() == nil {
+		t.Fatalf("expected the writer to be degraded with an error, got %+v", health)
+	}
+
+	n, err := writer.Write([]byte("discarded"))
+	if err != nil || n != len("discarded") {
+		t.Fatalf("degraded Write returned %d, %v - expected %d, nil", n, err, len("discarded"))
+	}
+
+	// Clear the problem.  The writer doesn't retry until the retry
+	// interval has passed.
+	os.Remove("blocker")
+	writer.Write([]byte("discarded"))
+	if !writer.Health().Degraded {
+		t.Fatal("writer retried the open too soon")
+	}
+
+	manualClock.Advance(retryInterval)
+	writer.Write([]byte(expectedMessage))
+
+	health = writer.Health()
+	if health.Degraded || writer.Err() != nil {
+		t.Fatalf("expected the writer to recover, got %+v", health)
+	}
+
+	contents, err := ioutil.ReadFile(expectedPathName)
+	if err != nil {
+		t.Fatalf("error reading logfile back - %v", err)
+	}
+	if string(contents) != expectedMessage {
+		t.Errorf("logfile contains \"%s\" - expected \"%s\"", string(contents), expectedMessage)
+	}
+}
+
+// TestDegradedWriterRecoversWithoutWrites checks that the log rotator retries
+// opening the log file, so a degraded Writer recovers even if nothing is
+// written.
+func TestDegradedWriterRecoversWithoutWrites(t *testing.T) {
+
+	// This test uses the filestore.
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	locationUTC, _ := time.LoadLocation("UTC")
+	manualClock := clock.NewManualClock(
+		time.Date(2020, time.February, 14, 12, 0, 0, 0, locationUTC))
+
+	writer, err := NewWithPolicy(manualClock, MaxSize(5, Daily()), ".", "foo.", ".bar")
+	if err != nil {
+		t.Fatalf("NewWithPolicy failed - %v", err)
+	}
+	defer writer.Close()
+
+	// A directory where the next file in the sequence should be stops the
+	// Writer opening it when the first file fills up.
+	err = os.Mkdir("foo.2020-02-14.1.bar", 0755)
+	if err != nil {
+		t.Fatalf("cannot create blocking directory - %v", err)
+	}
+	writer.Write([]byte("hello"))
+	writer.Write([]byte("world"))
+	if !writer.Health().Degraded {
+		t.Fatal("expected the writer to be degraded")
+	}
+
+	// Clear the problem and let the rotator wake up.
+	os.Remove("foo.2020-02-14.1.bar")
+	manualClock.BlockUntil(1)
+	manualClock.Advance(retryInterval)
+
+	for i := 0; i < 500 && writer.Health().Degraded; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if writer.Health().Degraded {
+		t.Fatal("expected the writer to recover without a write")
+	}
+	if _, err := os.Stat("foo.2020-02-14.1.bar"); err != nil {
+		t.Errorf("expected the next log file to be created - %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field is a key/value pair attached to a log message.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a Field.
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+// Entry is a log message as presented to an Encoder.
+type Entry struct {
+	Time      time.Time // The time from the logger's clock.
+	Level     uint8     // The level of the message.
+	LevelName string    // The name of the level, for example "info".
+	Message   string    // The message.
+	Fields    []Field   // The fields, in the order given.
+}
+
+// Encoder writes a log entry to a stream.
+type Encoder interface {
+	Encode(w io.Writer, entry *Entry) error
+}
+
+// TextEncoder writes the message as plain text, followed by the fields in
+// the form " key=value", adding a newline if the result doesn't end with one.
+// It doesn't write the timestamp or the level.  String values that contain
+// spaces or quotes are quoted.
+type TextEncoder struct{}
+
+// Encode satisfies the Encoder interface.
+func (e TextEncoder) Encode(w io.Writer, entry *Entry) error {
+	var b strings.Builder
+	b.WriteString(strings.TrimSuffix(entry.Message, "\n"))
+	for _, field := range entry.Fields {
+		value := fmt.Sprint(field.Value)
+		if strings.ContainsAny(value, " \t\n\"") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, " %s=%s", field.Key, value)
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// JSONEncoder writes each entry as a single line of JSON, for example:
+//
+//	{"time":"2020-02-14T15:42:11.789Z","level":"info","message":"connection accepted","connection":3}
+//
+// The fields follow the timestamp, level and message, in the order given.  A
+// value that can't be represented in JSON is written as a string.
+type JSONEncoder struct{}
+
+// Encode satisfies the Encoder interface.
+func (e JSONEncoder) Encode(w io.Writer, entry *Entry) error {
+	var b strings.Builder
+	b.WriteString("{")
+	writeJSONPair(&b, "time", entry.Time.Format(time.RFC3339Nano))
+	b.WriteString(",")
+	writeJSONPair(&b, "level", entry.LevelName)
+	b.WriteString(",")
+	writeJSONPair(&b, "message", entry.Message)
+	for _, field := range entry.Fields {
+		b.WriteString(",")
+		writeJSONPair(&b, field.Key, field.Value)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeJSONPair writes "key":value.
+func writeJSONPair(b *strings.Builder, key string, value interface{}) {
+	keyJSON, _ := json.Marshal(key)
+	b.Write(keyJSON)
+	b.WriteString(":")
+
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		valueJSON, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(valueJSON)
+}
+
+// levelName returns the name of a numeric level.
+func levelName(level uint8) string {
+	switch {
+	case level <= InfoLevel:
+		return "info"
+	case level == DebugLevel:
+		return "debug"
+	default:
+		return "trace"
+	}
+}
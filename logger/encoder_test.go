@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+)
+
+// TestJSONEncoder checks that a logger using the JSON encoder writes one line of
+// JSON per message, with the time taken from the logger's clock.
+func TestJSONEncoder(t *testing.T) {
+	const expectedLog = `{"time":"2020-02-14T15:42:11.789Z","level":"info","message":"connection accepted","connection":3,"peer":"192.168.1.2:4242"}
+{"time":"2020-02-14T15:42:11.789Z","level":"error","message":"read failed","error":"connection reset"}
+{"time":"2020-02-14T15:42:11.789Z","level":"info","message":"raw write"}
+`
+	locationUTC, _ := time.LoadLocation("UTC")
+	stoppedClock := clock.NewStoppedClock(2020, time.February, 14, 15, 42, 11, 789000000, locationUTC)
+	var buffer bytes.Buffer
+	log := New(ToWriter(&buffer), WithJSON(), WithClock(stoppedClock))
+	log.SetLogLevel(InfoLevel)
+
+	log.Info("connection accepted", F("connection", 3), F("peer", "192.168.1.2:4242"))
+	log.Error("read failed", F("error", errors.New("connection reset")))
+	log.Debug("not logged at this level", F("bytes", 42))
+	log.Write([]byte("raw write\n"))
+
+	if buffer.String() != expectedLog {
+		t.Errorf("expected log\n%s\ngot\n%s", expectedLog, buffer.String())
+	}
+}
+
+// TestTextEncoder checks that a logger using the default text encoder writes the
+// message followed by the fields.
+func TestTextEncoder(t *testing.T) {
+	const expectedLog = "connection accepted connection=3 peer=\"a b\"\nhello 42\n"
+
+	var buffer bytes.Buffer
+	log := New(ToWriter(&buffer))
+	log.SetLogLevel(TraceLevel)
+
+	log.Info("connection accepted", F("connection", 3), F("peer", "a b"))
+	log.Tracef("hello %d", 42)
+
+	if buffer.String() != expectedLog {
+		t.Errorf("expected log \"%s\", got \"%s\"", expectedLog, buffer.String())
+	}
+
+	if !log.Enabled(DebugLevel) {
+		t.Error("expected DebugLevel to be enabled at TraceLevel")
+	}
+	log.SetLogLevel(OffLevel)
+	if log.Enabled(ErrorLevel) {
+		t.Error("expected ErrorLevel to be disabled at OffLevel")
+	}
+}
@@ -1,56 +1,293 @@
 // The logger package provides a simple logger.  When logging is enabled, Write writes
-// to the file "./log.txt", appending to anything already there.  SetLogLevel enables 
+// to the file "./log.txt", appending to anything already there.  SetLogLevel enables
 // and disables logging.
-//	
+//
+// Options supplied to New can send the log somewhere else - another file (ToFile),
+// any io.Writer (ToWriter), the standard error stream (ToStderr) or a daily log
+// (ToDailyLog).
+//
+// Error, Warn, Info, Debug, Trace and Log write a message with a list of key/value
+// fields.  By default each message is written as plain text.  The WithJSON option
+// writes each message as a line of JSON containing a timestamp, the level, the
+// message and the fields.  The timestamp is taken from a clock.Clock, which can be
+// set with WithClock.
+//
+// The level also controls how much is logged.  Errorf, Warnf, Infof, Debugf and
+// Tracef each write a message if the current level is at least the level of that
+// kind of message.  Logf does the same for an arbitrary level.  Level 1 logs
+// errors, warnings and information, level 2 adds debugging messages and level 3
+// adds trace messages such as hex dumps of traffic.
 package logger
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync/atomic"
 
+	"github.com/goblimey/go-tools/clock"
+	"github.com/goblimey/go-tools/dailylogger"
 	"github.com/goblimey/go-tools/switchwriter"
 )
 
+// If the log level is not zero and no other destination is given, log to this file.
+const defaultLogFile = "./log.txt"
 
-// If the log level is not zero, log to this file.
-var logFile = "./log.txt"
+// The levels at which the levelled methods write their messages.
+const (
+	// OffLevel disables logging.
+	OffLevel uint8 = 0
+	// ErrorLevel is the level of Errorf messages.
+	ErrorLevel uint8 = 1
+	// WarnLevel is the level of Warnf messages.
+	WarnLevel uint8 = 1
+	// InfoLevel is the level of Infof messages.
+	InfoLevel uint8 = 1
+	// DebugLevel is the level of Debugf messages.
+	DebugLevel uint8 = 2
+	// TraceLevel is the level of Tracef messages.
+	TraceLevel uint8 = 3
+)
 
 type LoggerT struct {
-	level  uint8
-	writer *switchwriter.Writer
+	level       uint32 // The log level.  It's a uint8 but it's accessed atomically.
+	writer      *switchwriter.Writer
+	destination string                    // A description of the destination, for error messages.
+	open        func() (io.Writer, error) // Opens the destination when logging is enabled.
+	encoder     Encoder                   // Formats each message.
+	clock       clock.Clock               // Supplies the timestamps.
 }
 
 // This is a compile-time check that LoggerT implements the io.Writer interface.
 var _ io.Writer = (*LoggerT)(nil)
 
-// New creates a LoggerT object.
-func New() *LoggerT {
-	logger := LoggerT{0, switchwriter.New()}
+// Option is an option for New.
+type Option func(*LoggerT)
+
+// ToFile makes the logger append to the named file, creating it if necessary.
+// The file is opened when logging is enabled and closed when it's disabled.
+func ToFile(pathname string) Option {
+	return func(logger *LoggerT) {
+		logger.destination = pathname
+		logger.open = func() (io.Writer, error) {
+			return os.OpenFile(pathname, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		}
+	}
+}
+
+// ToWriter makes the logger write to w.  The logger never closes w.
+func ToWriter(w io.Writer) Option {
+	return func(logger *LoggerT) {
+		logger.destination = fmt.Sprintf("%T", w)
+		logger.open = func() (io.Writer, error) {
+			// Hide any Close method so that the switchwriter doesn't close w.
+			return struct{ io.Writer }{w}, nil
+		}
+	}
+}
+
+// ToStderr makes the logger write to the standard error stream.
+func ToStderr() Option {
+	return func(logger *LoggerT) {
+		ToWriter(os.Stderr)(logger)
+		logger.destination = "stderr"
+	}
+}
+
+// ToDailyLog makes the logger write to a daily log.  The logger never closes
+// the daily log.
+func ToDailyLog(dw *dailylogger.Writer) Option {
+	return func(logger *LoggerT) {
+		ToWriter(dw)(logger)
+		logger.destination = "daily log"
+	}
+}
+
+// WithEncoder makes the logger format each message using the given encoder.
+func WithEncoder(encoder Encoder) Option {
+	return func(logger *LoggerT) {
+		logger.encoder = encoder
+	}
+}
+
+// WithJSON makes the logger write each message as a line of JSON.
+func WithJSON() Option {
+	return WithEncoder(JSONEncoder{})
+}
+
+// WithClock makes the logger take its timestamps from the given clock.
+func WithClock(cl clock.Clock) Option {
+	return func(logger *LoggerT) {
+		logger.clock = cl
+	}
+}
+
+// New creates a LoggerT object.  By default the logger writes plain text to
+// "./log.txt".  The options can change that.  Logging is initially disabled.
+func New(options ...Option) *LoggerT {
+	// The switchwriter closes the log file each time it's switched away from.
+	logger := LoggerT{writer: switchwriter.NewOwner(),
+		encoder: TextEncoder{}, clock: clock.NewSystemClock()}
+	ToFile(defaultLogFile)(&logger)
+	for _, option := range options {
+		option(&logger)
+	}
 	return &logger
 }
 
 // SetLogLevel sets the LoggerT's log level.  Level 0 (or negative) disables logging.
-// Level 1 or greater enables logging.
-//
-func (logger *LoggerT) SetLogLevel(level uint8) {
-	logger.level = level
+// Level 1 or greater enables logging.  If the log file can't be opened, SetLogLevel
+// returns an error and leaves the level and the log file as they were.
+func (logger *LoggerT) SetLogLevel(level uint8) error {
 	if level <= 0 {
-		logger.writer.SwitchTo(nil)
+		atomic.StoreUint32(&logger.level, uint32(level))
+		_, err := logger.writer.SwitchTo(nil)
+		if err != nil {
+			return fmt.Errorf("error closing log file - %v", err)
+		}
 	} else {
-		f, err := os.OpenFile(logFile, os.O_RDWR|os.O_APPEND, 0666)
+		f, err := logger.open()
 		if err != nil {
-			fmt.Printf("cannot open %s for append - %v", logFile, err)
-			os.Exit(1)
+			return fmt.Errorf("cannot open %s for append - %v", logger.destination, err)
+		}
+		atomic.StoreUint32(&logger.level, uint32(level))
+		_, err = logger.writer.SwitchTo(f)
+		if err != nil {
+			return fmt.Errorf("error closing previous log file - %v", err)
 		}
-		logger.writer.SwitchTo(f)
 	}
+	return nil
 }
 
 // Write writes the contents of p to the logger's writer.  If the
 // log level is greater than zero, that will write to the log file,
-// otherwise the byte are discarded.
-func (logger LoggerT) Write(p []byte) (int, error) {
+// otherwise the byte are discarded.  If the logger isn't writing plain
+// text, the contents are written as the message of an entry at InfoLevel.
+func (logger *LoggerT) Write(p []byte) (int, error) {
+	if _, ok := logger.encoder.(TextEncoder); !ok {
+		if logger.Enabled(InfoLevel) {
+			message := strings.TrimSuffix(string(p), "\n")
+			logger.emit(InfoLevel, "info", message, nil)
+		}
+		return len(p), nil
+	}
 	n, err := logger.writer.Write(p)
 	return n, err
 }
+
+// Close disables logging and closes the log file, if the logger opened one.
+func (logger *LoggerT) Close() error {
+	atomic.StoreUint32(&logger.level, 0)
+	return logger.writer.Close()
+}
+
+// Level returns the current log level.
+func (logger *LoggerT) Level() uint8 {
+	return uint8(atomic.LoadUint32(&logger.level))
+}
+
+// Enabled returns true if a message at the given level would be logged.  It's
+// cheap, so a caller can use it to avoid formatting a message that won't be
+// written.  Level 0 is never enabled.
+func (logger *LoggerT) Enabled(level uint8) bool {
+	return level > 0 && logger.Level() >= level
+}
+
+// Logf writes a message to the log if the current log level is at least the
+// given level.  The arguments are handled as by fmt.Printf.  A newline is
+// added if the message doesn't end with one.
+func (logger *LoggerT) Logf(level uint8, format string, args ...interface{}) {
+	logger.logf(level, levelName(level), format, args...)
+}
+
+// Errorf logs a message at ErrorLevel.
+func (logger *LoggerT) Errorf(format string, args ...interface{}) {
+	logger.logf(ErrorLevel, "error", format, args...)
+}
+
+// Warnf logs a message at WarnLevel.
+func (logger *LoggerT) Warnf(format string, args ...interface{}) {
+	logger.logf(WarnLevel, "warn", format, args...)
+}
+
+// Infof logs a message at InfoLevel.
+func (logger *LoggerT) Infof(format string, args ...interface{}) {
+	logger.logf(InfoLevel, "info", format, args...)
+}
+
+// Debugf logs a message at DebugLevel.
+func (logger *LoggerT) Debugf(format string, args ...interface{}) {
+	logger.logf(DebugLevel, "debug", format, args...)
+}
+
+// Tracef logs a message at TraceLevel.
+func (logger *LoggerT) Tracef(format string, args ...interface{}) {
+	logger.logf(TraceLevel, "trace", format, args...)
+}
+
+// Log writes a message with key/value fields to the log if the current log
+// level is at least the given level.
+func (logger *LoggerT) Log(level uint8, message string, fields ...Field) {
+	logger.log(level, levelName(level), message, fields)
+}
+
+// Error logs a message with fields at ErrorLevel.
+func (logger *LoggerT) Error(message string, fields ...Field) {
+	logger.log(ErrorLevel, "error", message, fields)
+}
+
+// Warn logs a message with fields at WarnLevel.
+func (logger *LoggerT) Warn(message string, fields ...Field) {
+	logger.log(WarnLevel, "warn", message, fields)
+}
+
+// Info logs a message with fields at InfoLevel.
+func (logger *LoggerT) Info(message string, fields ...Field) {
+	logger.log(InfoLevel, "info", message, fields)
+}
+
+// Debug logs a message with fields at DebugLevel.
+func (logger *LoggerT) Debug(message string, fields ...Field) {
+	logger.log(DebugLevel, "debug", message, fields)
+}
+
+// Trace logs a message with fields at TraceLevel.
+func (logger *LoggerT) Trace(message string, fields ...Field) {
+	logger.log(TraceLevel, "trace", message, fields)
+}
+
+// logf formats a message and logs it if the level is enabled.
+func (logger *LoggerT) logf(level uint8, name string, format string, args ...interface{}) {
+	if !logger.Enabled(level) {
+		return
+	}
+	logger.emit(level, name, fmt.Sprintf(format, args...), nil)
+}
+
+// log logs a message with fields if the level is enabled.
+func (logger *LoggerT) log(level uint8, name string, message string, fields []Field) {
+	if !logger.Enabled(level) {
+		return
+	}
+	logger.emit(level, name, message, fields)
+}
+
+// emit encodes an entry and writes it to the log.
+func (logger *LoggerT) emit(level uint8, name string, message string, fields []Field) {
+	entry := Entry{
+		Time:      logger.clock.Now(),
+		Level:     level,
+		LevelName: name,
+		Message:   message,
+		Fields:    fields,
+	}
+	var buffer bytes.Buffer
+	err := logger.encoder.Encode(&buffer, &entry)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot encode log message %q - %v\n", message, err)
+		return
+	}
+	logger.writer.Write(buffer.Bytes())
+}
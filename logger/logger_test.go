@@ -0,0 +1,192 @@
+package logger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+	"github.com/goblimey/go-tools/dailylogger"
+	ts "github.com/goblimey/go-tools/testsupport"
+)
+
+// TestLevels checks which messages are written at each log level.
+func TestLevels(t *testing.T) {
+	var testData = []struct {
+		description string
+		level       uint8
+		expectedLog string
+	}{
+		{"off", OffLevel, ""},
+		{"info", InfoLevel, "error\nwarn\ninfo\nlogf 1\n"},
+		{"debug", DebugLevel, "error\nwarn\ninfo\ndebug\nlogf 1\nlogf 2\n"},
+		{"trace", TraceLevel, "error\nwarn\ninfo\ndebug\ntrace\nlogf 1\nlogf 2\nlogf 3\n"},
+	}
+
+	for _, td := range testData {
+		var buffer bytes.Buffer
+		log := New(ToWriter(&buffer))
+		err := log.SetLogLevel(td.level)
+		if err != nil {
+			t.Fatalf("%s: SetLogLevel failed - %v", td.description, err)
+		}
+
+		log.Errorf("error")
+		log.Warnf("warn")
+		log.Infof("info")
+		log.Debugf("debug")
+		log.Tracef("trace")
+		for level := uint8(1); level <= TraceLevel; level++ {
+			log.Logf(level, "logf %d", level)
+		}
+
+		if buffer.String() != td.expectedLog {
+			t.Errorf("%s: expected log \"%s\", got \"%s\"", td.description, td.expectedLog, buffer.String())
+		}
+		for level := uint8(0); level <= TraceLevel; level++ {
+			expected := level > 0 && level <= td.level
+			if log.Enabled(level) != expected {
+				t.Errorf("%s: expected Enabled(%d) to be %v", td.description, level, expected)
+			}
+		}
+		if log.Level() != td.level {
+			t.Errorf("%s: expected Level() to return %d, got %d", td.description, td.level, log.Level())
+		}
+	}
+}
+
+// TestWriteWhileSettingLevel checks that Write can run at the same time as
+// SetLogLevel.  It's only useful with the race detector.
+func TestWriteWhileSettingLevel(t *testing.T) {
+	var buffer bytes.Buffer
+	log := New(ToWriter(&buffer))
+	log.SetLogLevel(InfoLevel)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			log.SetLogLevel(InfoLevel)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		log.Write([]byte("x"))
+	}
+	wg.Wait()
+}
+
+// TestToFile checks that a logger created with ToFile writes to the file and
+// that SetLogLevel returns an error if the file can't be opened.
+func TestToFile(t *testing.T) {
+
+	// This test uses the filestore.
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	log := New(ToFile("test.log"))
+	err = log.SetLogLevel(InfoLevel)
+	if err != nil {
+		t.Fatalf("SetLogLevel failed - %v", err)
+	}
+	log.Infof("hello")
+	err = log.Close()
+	if err != nil {
+		t.Fatalf("Close failed - %v", err)
+	}
+
+	contents, err := ioutil.ReadFile("test.log")
+	if err != nil {
+		t.Fatalf("cannot read the log - %v", err)
+	}
+	if string(contents) != "hello\n" {
+		t.Errorf("expected the log to contain \"hello\\n\", got \"%s\"", contents)
+	}
+
+	// The directory doesn't exist, so the file can't be opened.  The logger
+	// stays disabled.
+	log = New(ToFile(filepath.Join("nonexistent", "test.log")))
+	err = log.SetLogLevel(InfoLevel)
+	if err == nil {
+		t.Error("expected an error from an unusable path")
+	}
+	if log.Level() != OffLevel {
+		t.Errorf("expected the logger to stay disabled, got level %d", log.Level())
+	}
+}
+
+// TestToDailyLog checks that a logger created with ToDailyLog writes to the
+// daily log.
+func TestToDailyLog(t *testing.T) {
+
+	// This test uses the filestore.
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	locationUTC, _ := time.LoadLocation("UTC")
+	manualClock := clock.NewManualClock(time.Date(2020, time.February, 14, 12, 0, 0, 0, locationUTC))
+	dw, err := dailylogger.NewWithClock(manualClock, ".", "test.", ".log")
+	if err != nil {
+		t.Fatalf("cannot create the daily log - %v", err)
+	}
+	defer dw.Close()
+
+	log := New(ToDailyLog(dw))
+	err = log.SetLogLevel(InfoLevel)
+	if err != nil {
+		t.Fatalf("SetLogLevel failed - %v", err)
+	}
+	log.Infof("hello")
+
+	contents, err := ioutil.ReadFile("test.2020-02-14.log")
+	if err != nil {
+		t.Fatalf("cannot read the daily log - %v", err)
+	}
+	if string(contents) != "hello\n" {
+		t.Errorf("expected the daily log to contain \"hello\\n\", got \"%s\"", contents)
+	}
+}
+
+// TestToStderr checks that a logger created with ToStderr writes to the
+// standard error stream and doesn't close it.
+func TestToStderr(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("cannot create a pipe - %v", err)
+	}
+	defer r.Close()
+	stderr := os.Stderr
+	os.Stderr = w
+	log := New(ToStderr())
+	os.Stderr = stderr
+
+	log.SetLogLevel(InfoLevel)
+	log.Infof("hello")
+	log.Close()
+
+	// The logger must not have closed the stream.
+	_, err = w.Write([]byte("still open\n"))
+	if err != nil {
+		t.Fatalf("the logger closed the stream - %v", err)
+	}
+	w.Close()
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("cannot read the pipe - %v", err)
+	}
+	if string(contents) != "hello\nstill open\n" {
+		t.Errorf("expected \"hello\\nstill open\\n\", got \"%s\"", contents)
+	}
+}
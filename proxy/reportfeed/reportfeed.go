@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -20,31 +21,126 @@ type Buffer struct {
 	ContentLength int
 }
 
-// ReportFeed satisfies the status-reporter ReportFeedT interface.
+// BufferReport describes an input or output buffer in the structured status report.
+type BufferReport struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Connection uint64    `json:"connection"`
+	Length     int       `json:"length"`
+	Hex        string    `json:"hex"` // The contents of the buffer in hexadecimal.
+}
+
+// StatusReport is the structured status report.  A buffer is nil if none has been seen yet.
+type StatusReport struct {
+	LastClientBuffer *BufferReport      `json:"lastClientBuffer"`
+	LastServerBuffer *BufferReport      `json:"lastServerBuffer"`
+	UpstreamFailures uint64             `json:"upstreamFailures"` // The number of times the server couldn't be reached.
+	Connections      []ConnectionReport `json:"connections"`      // The open connections, in order of ID.
+	Upstreams        []UpstreamReport   `json:"upstreams"`        // The servers, in the order they are listed.
+}
+
+// ConnectionReport describes an open connection in the status report.
+type ConnectionReport struct {
+	ID       uint64    `json:"id"`
+	Peer     string    `json:"peer"`               // The address of the client.
+	Upstream string    `json:"upstream,omitempty"` // The server in use, once it has been reached.
+	Opened   time.Time `json:"opened"`
+}
+
+// UpstreamReport describes an upstream server in the status report.
+type UpstreamReport struct {
+	Address string `json:"address"`
+	Healthy bool   `json:"healthy"` // False if the server has failed its health checks.
+	Open    int    `json:"open"`    // The open connections using the server.
+	Total   uint64 `json:"total"`   // The connections that have used the server.
+}
+
+// BufferEvent is the data of the "buffer" event published when a buffer is recorded.
+type BufferEvent struct {
+	Direction  string `json:"direction"` // "client_to_server" or "server_to_client".
+	Connection uint64 `json:"connection"`
+	Length     int    `json:"length"`
+}
+
+// ConnectionEvent is the data of the "connection" event published when a connection opens, is connected to
+// an upstream server or closes.  When it's connected, the peer is the upstream server.
+type ConnectionEvent struct {
+	State      string `json:"state"` // "opened", "connected" or "closed".
+	Connection uint64 `json:"connection"`
+	Peer       string `json:"peer,omitempty"`
+}
+
+// UpstreamFailureEvent is the data of the "upstream" event published when the proxy can't reach the server
+// for a connection.
+type UpstreamFailureEvent struct {
+	Connection uint64 `json:"connection"`
+	Error      string `json:"error"`
+}
+
+// UpstreamHealthEvent is the data of the "health" event published when an upstream server fails its health
+// checks or recovers.
+type UpstreamHealthEvent struct {
+	Upstream string `json:"upstream"`
+	Healthy  bool   `json:"healthy"`
+}
+
+// ReportFeed satisfies the status-reporter ReportFeedT interface.  It publishes
+// an event whenever it records a buffer or a connection opens or closes, and it
+// runs the health checks that the proxy adds to it.
 type ReportFeed struct {
+	*statusreporter.Broadcaster
+	statusreporter.HealthRegistry
 	logger           *logger.LoggerT
 	lastClientBuffer *Buffer
 	lastServerBuffer *Buffer
+	connections      uint64                       // The number of connections accepted.
+	openConnections  int64                        // The number of connections currently open.
+	clientBytes      uint64                       // The number of bytes from the client to the server.
+	serverBytes      uint64                       // The number of bytes from the server to the client.
+	upstreamFailures uint64                       // The number of times the server couldn't be reached.
+	dialFailures     map[string]uint64            // Failed attempts to reach each upstream server.
+	connectionsByID  map[uint64]*ConnectionReport // The open connections.
+	upstreams        []UpstreamReport             // The upstream servers.
 	mutex            sync.Mutex
 }
 
-// This is a compile-time check that ReportFeed implements the statusreporter.ReportFeedT interface.
-var _ statusreporter.ReportFeedT = (*ReportFeed)(nil)
+// This is a compile-time check that ReportFeed implements the statusreporter interfaces.
+var (
+	_ statusreporter.ReportFeedT          = (*ReportFeed)(nil)
+	_ statusreporter.StructuredReportFeed = (*ReportFeed)(nil)
+	_ statusreporter.MetricsFeed          = (*ReportFeed)(nil)
+	_ statusreporter.EventFeed            = (*ReportFeed)(nil)
+	_ statusreporter.LogLevelFeed         = (*ReportFeed)(nil)
+	_ statusreporter.HealthFeed           = (*ReportFeed)(nil)
+)
 
 // New creates and returns a new ReportFeed object
 func New(logger *logger.LoggerT) *ReportFeed {
 	var reportFeed ReportFeed
+	reportFeed.Broadcaster = statusreporter.NewBroadcaster()
 	reportFeed.SetLogger(logger)
 	return &reportFeed
 }
 
 //SetLogLevel satisfies the ReportFeedT interface.
 func (rf *ReportFeed) SetLogLevel(level uint8) {
-	rf.logger.SetLogLevel(level)
+	err := rf.logger.SetLogLevel(level)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot set log level %d - %v\n", level, err)
+	}
+}
+
+//GetLogLevel satisfies the LogLevelFeed interface.
+func (rf *ReportFeed) GetLogLevel() uint8 {
+	return rf.logger.Level()
 }
 
 //Status satisfies the ReportFeedT interface.
 func (rf *ReportFeed) Status() []byte {
+	return rf.status("")
+}
+
+// status produces the status report with the given prefix on its element ids.
+func (rf *ReportFeed) status(idPrefix string) []byte {
 	clientLeader := "no input buffer"
 	clientHexDump := ""
 	serverLeader := "no output buffer"
@@ -52,7 +148,6 @@ func (rf *ReportFeed) Status() []byte {
 	rf.mutex.Lock()
 	defer rf.mutex.Unlock()
 	if rf.lastClientBuffer != nil && rf.lastClientBuffer.Content != nil {
-		fmt.Fprintf(os.Stderr, "client buffer")
 		clientLeader = fmt.Sprintf("From Client [%d]:\n%s\n", rf.lastClientBuffer.Source,
 			rf.lastClientBuffer.Timestamp.Format("Mon Jan _2 15:04:05 2006"))
 
@@ -60,22 +155,157 @@ func (rf *ReportFeed) Status() []byte {
 			Sanitise(hex.Dump((*rf.lastClientBuffer.Content)[:rf.lastClientBuffer.ContentLength]))
 	}
 	if rf.lastServerBuffer != nil && rf.lastServerBuffer.Content != nil {
-		fmt.Fprintf(os.Stderr, "server buffer")
 		serverLeader = fmt.Sprintf("To Server [%d]:\n%s\n", rf.lastServerBuffer.Source,
 			rf.lastServerBuffer.Timestamp.Format("Mon Jan _2 15:04:05 2006"))
 		serverHexDump =
 			Sanitise(hex.Dump((*rf.lastServerBuffer.Content)[:rf.lastServerBuffer.ContentLength]))
 	}
 
+	connections := "no open connections"
+	if len(rf.connectionsByID) > 0 {
+		var b strings.Builder
+		b.WriteString("<table>\n<tr><th>Connection</th><th>Client</th><th>Upstream</th></tr>\n")
+		for _, report := range rf.connectionReports() {
+			fmt.Fprintf(&b, "<tr><td>%d</td><td>%s</td><td>%s</td></tr>\n",
+				report.ID, Sanitise(report.Peer), Sanitise(report.Upstream))
+		}
+		b.WriteString("</table>")
+		connections = b.String()
+	}
+
+	upstreams := "no upstream servers"
+	if len(rf.upstreams) > 0 {
+		var b strings.Builder
+		b.WriteString("<table>\n<tr><th>Upstream</th><th>Healthy</th><th>Open</th><th>Total</th></tr>\n")
+		for _, report := range rf.upstreamReports() {
+			fmt.Fprintf(&b, "<tr><td>%s</td><td>%t</td><td>%d</td><td>%d</td></tr>\n",
+				Sanitise(report.Address), report.Healthy, report.Open, report.Total)
+		}
+		b.WriteString("</table>")
+		upstreams = b.String()
+	}
+
 	reportBody := fmt.Sprintf(reportFormat,
+		idPrefix,
 		clientLeader,
 		clientHexDump,
 		serverLeader,
-		serverHexDump)
+		serverHexDump,
+		connections,
+		upstreams)
 
 	return []byte(reportBody)
 }
 
+//StructuredStatus satisfies the StructuredReportFeed interface.
+func (rf *ReportFeed) StructuredStatus() interface{} {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	return StatusReport{
+		LastClientBuffer: makeBufferReport(rf.lastClientBuffer),
+		LastServerBuffer: makeBufferReport(rf.lastServerBuffer),
+		UpstreamFailures: rf.upstreamFailures,
+		Connections:      rf.connectionReports(),
+		Upstreams:        rf.upstreamReports(),
+	}
+}
+
+//Metrics satisfies the MetricsFeed interface.
+func (rf *ReportFeed) Metrics() []statusreporter.Metric {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	const bytesHelp = "Number of bytes passed through the proxy."
+	metrics := []statusreporter.Metric{
+		{Name: "proxy_connections_total", Help: "Number of connections accepted from clients.",
+			Type: statusreporter.Counter, Value: float64(rf.connections)},
+		{Name: "proxy_connections_open", Help: "Number of connections currently open.",
+			Type: statusreporter.Gauge, Value: float64(rf.openConnections)},
+		{Name: "proxy_bytes_total", Help: bytesHelp, Type: statusreporter.Counter,
+			Labels: map[string]string{"direction": "client_to_server"}, Value: float64(rf.clientBytes)},
+		{Name: "proxy_bytes_total", Help: bytesHelp, Type: statusreporter.Counter,
+			Labels: map[string]string{"direction": "server_to_client"}, Value: float64(rf.serverBytes)},
+		{Name: "proxy_upstream_failures_total", Help: "Number of times the server could not be reached.",
+			Type: statusreporter.Counter, Value: float64(rf.upstreamFailures)},
+	}
+	upstreams := make([]string, 0, len(rf.dialFailures))
+	for upstream := range rf.dialFailures {
+		upstreams = append(upstreams, upstream)
+	}
+	sort.Strings(upstreams)
+	for _, upstream := range upstreams {
+		metrics = append(metrics, statusreporter.Metric{Name: "proxy_upstream_dial_failures_total",
+			Help: "Number of failed attempts to reach each upstream server.", Type: statusreporter.Counter,
+			Labels: map[string]string{"upstream": upstream}, Value: float64(rf.dialFailures[upstream])})
+	}
+	for _, report := range rf.upstreamReports() {
+		labels := map[string]string{"upstream": report.Address}
+		healthy := 0.0
+		if report.Healthy {
+			healthy = 1
+		}
+		metrics = append(metrics,
+			statusreporter.Metric{Name: "proxy_upstream_connections_open",
+				Help: "Number of open connections using each upstream server.", Type: statusreporter.Gauge,
+				Labels: labels, Value: float64(report.Open)},
+			statusreporter.Metric{Name: "proxy_upstream_connections_total",
+				Help: "Number of connections that have used each upstream server.", Type: statusreporter.Counter,
+				Labels: labels, Value: float64(report.Total)},
+			statusreporter.Metric{Name: "proxy_upstream_healthy",
+				Help: "1 if the upstream server is passing its health checks, otherwise 0.",
+				Type: statusreporter.Gauge, Labels: labels, Value: healthy})
+	}
+	return metrics
+}
+
+// connectionReports returns the open connections in order of ID.
+func (rf *ReportFeed) connectionReports() []ConnectionReport {
+	reports := make([]ConnectionReport, 0, len(rf.connectionsByID))
+	for _, report := range rf.connectionsByID {
+		reports = append(reports, *report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID < reports[j].ID })
+	return reports
+}
+
+// upstreamReports returns the upstream servers with their open connections counted.  It doesn't apply the
+// lock so it should only be called by a function that does.
+func (rf *ReportFeed) upstreamReports() []UpstreamReport {
+	reports := make([]UpstreamReport, len(rf.upstreams))
+	copy(reports, rf.upstreams)
+	for _, connection := range rf.connectionsByID {
+		for i := range reports {
+			if reports[i].Address == connection.Upstream {
+				reports[i].Open++
+			}
+		}
+	}
+	return reports
+}
+
+// findUpstream returns the upstream server with the given address, adding it if it's not known.
+func (rf *ReportFeed) findUpstream(address string) *UpstreamReport {
+	for i := range rf.upstreams {
+		if rf.upstreams[i].Address == address {
+			return &rf.upstreams[i]
+		}
+	}
+	rf.upstreams = append(rf.upstreams, UpstreamReport{Address: address, Healthy: true})
+	return &rf.upstreams[len(rf.upstreams)-1]
+}
+
+// makeBufferReport creates a BufferReport from a Buffer.  It returns nil if there is no buffer.
+func makeBufferReport(buffer *Buffer) *BufferReport {
+	if buffer == nil || buffer.Content == nil {
+		return nil
+	}
+	return &BufferReport{
+		Timestamp:  buffer.Timestamp,
+		Connection: buffer.Source,
+		Length:     buffer.ContentLength,
+		Hex:        hex.EncodeToString((*buffer.Content)[:buffer.ContentLength]),
+	}
+}
+
 // SetLogger sets the logger.
 func (rf *ReportFeed) SetLogger(logger *logger.LoggerT) {
 	rf.logger = logger
@@ -86,6 +316,9 @@ func (rf *ReportFeed) RecordClientBuffer(buffer *[]byte, source uint64, length i
 	rf.mutex.Lock()
 	defer rf.mutex.Unlock()
 	rf.lastClientBuffer = &Buffer{time.Now(), uint64(source), buffer, length}
+	rf.clientBytes += uint64(length)
+	rf.Publish(statusreporter.Event{Type: "buffer",
+		Data: BufferEvent{"client_to_server", source, length}})
 }
 
 // RecordServerBuffer takes a timestamped copy of a server buffer.
@@ -93,6 +326,78 @@ func (rf *ReportFeed) RecordServerBuffer(buffer *[]byte, source uint64, length i
 	rf.mutex.Lock()
 	defer rf.mutex.Unlock()
 	rf.lastServerBuffer = &Buffer{time.Now(), uint64(source), buffer, length}
+	rf.serverBytes += uint64(length)
+	rf.Publish(statusreporter.Event{Type: "buffer",
+		Data: BufferEvent{"server_to_client", source, length}})
+}
+
+// RecordConnectionOpened counts a connection accepted from a client.
+func (rf *ReportFeed) RecordConnectionOpened(id uint64, peer string) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	rf.connections++
+	rf.openConnections++
+	if rf.connectionsByID == nil {
+		rf.connectionsByID = make(map[uint64]*ConnectionReport)
+	}
+	rf.connectionsByID[id] = &ConnectionReport{ID: id, Peer: peer, Opened: time.Now()}
+	rf.Publish(statusreporter.Event{Type: "connection", Data: ConnectionEvent{"opened", id, peer}})
+}
+
+// RecordConnectionClosed counts the closing of a connection.
+func (rf *ReportFeed) RecordConnectionClosed(id uint64) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	rf.openConnections--
+	delete(rf.connectionsByID, id)
+	rf.Publish(statusreporter.Event{Type: "connection", Data: ConnectionEvent{"closed", id, ""}})
+}
+
+// RecordUpstream records the upstream server that a connection is using.
+func (rf *ReportFeed) RecordUpstream(id uint64, upstream string) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	if report, ok := rf.connectionsByID[id]; ok {
+		report.Upstream = upstream
+	}
+	rf.findUpstream(upstream).Total++
+	rf.Publish(statusreporter.Event{Type: "connection", Data: ConnectionEvent{"connected", id, upstream}})
+}
+
+// SetUpstreams sets the list of upstream servers to report on.  They are all assumed to be healthy until
+// RecordUpstreamHealth says otherwise.
+func (rf *ReportFeed) SetUpstreams(upstreams []string) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	for _, upstream := range upstreams {
+		rf.findUpstream(upstream)
+	}
+}
+
+// RecordUpstreamHealth records that an upstream server has failed its health checks or recovered.
+func (rf *ReportFeed) RecordUpstreamHealth(upstream string, healthy bool) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	rf.findUpstream(upstream).Healthy = healthy
+	rf.Publish(statusreporter.Event{Type: "health", Data: UpstreamHealthEvent{upstream, healthy}})
+}
+
+// RecordDialFailure counts a failed attempt to reach an upstream server.
+func (rf *ReportFeed) RecordDialFailure(upstream string) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	if rf.dialFailures == nil {
+		rf.dialFailures = make(map[string]uint64)
+	}
+	rf.dialFailures[upstream]++
+}
+
+// RecordUpstreamFailure counts a failure to reach the server for a connection.
+func (rf *ReportFeed) RecordUpstreamFailure(id uint64, err error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	rf.upstreamFailures++
+	rf.Publish(statusreporter.Event{Type: "upstream", Data: UpstreamFailureEvent{id, err.Error()}})
 }
 
 // Sanitise edits a string, replacing some dangerous HTML characters.
@@ -1,10 +1,13 @@
 package reportfeed
 
 import (
+	"errors"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/goblimey/go-tools/logger"
+	"github.com/goblimey/go-tools/statusreporter"
 )
 
 // TestSanitise tests the Sanitise function.
@@ -43,6 +46,21 @@ func TestStatus(t *testing.T) {
 </div>
 </code>
 </pre>
+<h3>Connections</h3>
+<div id='connections'>
+<table>
+<tr><th>Connection</th><th>Client</th><th>Upstream</th></tr>
+<tr><td>1</td><td>192.168.1.2:4242</td><td>caster.example.com:2101</td></tr>
+</table>
+</div>
+<h3>Upstreams</h3>
+<div id='upstreams'>
+<table>
+<tr><th>Upstream</th><th>Healthy</th><th>Open</th><th>Total</th></tr>
+<tr><td>caster.example.com:2101</td><td>true</td><td>1</td><td>1</td></tr>
+<tr><td>spare.example.com:2101</td><td>false</td><td>0</td><td>0</td></tr>
+</table>
+</div>
 `
 	regex := regexp.MustCompile(reduceString(expectedResultRegex))
 	clientBuffer := []byte("foo")
@@ -50,6 +68,10 @@ func TestStatus(t *testing.T) {
 
 	log := logger.New()
 	reportFeed := New(log)
+	reportFeed.SetUpstreams([]string{"caster.example.com:2101", "spare.example.com:2101"})
+	reportFeed.RecordUpstreamHealth("spare.example.com:2101", false)
+	reportFeed.RecordConnectionOpened(1, "192.168.1.2:4242")
+	reportFeed.RecordUpstream(1, "caster.example.com:2101")
 
 	// Record only two characters of the client buffer.
 	reportFeed.RecordClientBuffer(&clientBuffer, 0, 2)
@@ -72,3 +94,132 @@ func reduceString(str string) string {
 	re = regexp.MustCompile(`[ \t]+`)
 	return re.ReplaceAllString(str, " ")
 }
+
+// TestStructuredStatus tests the StructuredStatus function.
+func TestStructuredStatus(t *testing.T) {
+	clientBuffer := []byte("foo")
+
+	log := logger.New()
+	reportFeed := New(log)
+
+	report := reportFeed.StructuredStatus().(StatusReport)
+	if report.LastClientBuffer != nil || report.LastServerBuffer != nil {
+		t.Errorf("Expected no buffers in the report, got %+v", report)
+	}
+
+	// Record only two characters of the client buffer.
+	reportFeed.RecordClientBuffer(&clientBuffer, 3, 2)
+
+	report = reportFeed.StructuredStatus().(StatusReport)
+	if report.LastClientBuffer == nil {
+		t.Fatal("Expected a client buffer in the report")
+	}
+	if report.LastClientBuffer.Connection != 3 ||
+		report.LastClientBuffer.Length != 2 ||
+		report.LastClientBuffer.Hex != "666f" {
+
+		t.Errorf("Expected connection 3, length 2, hex 666f, got %+v", *report.LastClientBuffer)
+	}
+	if report.LastServerBuffer != nil {
+		t.Errorf("Expected no server buffer in the report, got %+v", *report.LastServerBuffer)
+	}
+
+	reportFeed.RecordConnectionOpened(4, "192.168.1.2:4242")
+	reportFeed.RecordConnectionOpened(3, "192.168.1.3:4242")
+	reportFeed.RecordUpstream(3, "caster.example.com:2101")
+	report = reportFeed.StructuredStatus().(StatusReport)
+	if len(report.Connections) != 2 ||
+		report.Connections[0].ID != 3 || report.Connections[0].Upstream != "caster.example.com:2101" ||
+		report.Connections[1].ID != 4 || report.Connections[1].Upstream != "" {
+
+		t.Errorf("Expected connections 3 (using caster.example.com:2101) and 4, got %+v", report.Connections)
+	}
+	reportFeed.RecordConnectionClosed(3)
+	report = reportFeed.StructuredStatus().(StatusReport)
+	if len(report.Connections) != 1 || report.Connections[0].ID != 4 {
+		t.Errorf("Expected connection 4, got %+v", report.Connections)
+	}
+	expected := UpstreamReport{Address: "caster.example.com:2101", Healthy: true, Open: 0, Total: 1}
+	if len(report.Upstreams) != 1 || report.Upstreams[0] != expected {
+		t.Errorf("Expected upstream %+v, got %+v", expected, report.Upstreams)
+	}
+}
+
+// TestMetrics tests the Metrics function.
+func TestMetrics(t *testing.T) {
+	clientBuffer := []byte("foo")
+	serverBuffer := []byte("<bar>")
+
+	log := logger.New()
+	reportFeed := New(log)
+
+	reportFeed.RecordConnectionOpened(1, "foo")
+	reportFeed.RecordConnectionOpened(2, "bar")
+	reportFeed.RecordConnectionClosed(1)
+	reportFeed.RecordClientBuffer(&clientBuffer, 0, 2)
+	reportFeed.RecordClientBuffer(&clientBuffer, 0, 3)
+	reportFeed.RecordServerBuffer(&serverBuffer, 1, len(serverBuffer))
+	reportFeed.RecordUpstreamFailure(3, errors.New("connection refused"))
+	reportFeed.RecordDialFailure("b:2101")
+	reportFeed.RecordDialFailure("a:2101")
+	reportFeed.RecordDialFailure("b:2101")
+	reportFeed.SetUpstreams([]string{"a:2101", "b:2101"})
+	reportFeed.RecordUpstream(2, "b:2101")
+	reportFeed.RecordUpstreamHealth("a:2101", false)
+
+	var b strings.Builder
+	err := statusreporter.WriteMetrics(&b, reportFeed.Metrics())
+	if err != nil {
+		t.Fatalf("WriteMetrics failed - %v", err)
+	}
+	for _, want := range []string{
+		"\nproxy_connections_total 2\n",
+		"\nproxy_connections_open 1\n",
+		"\nproxy_bytes_total{direction=\"client_to_server\"} 5\n",
+		"\nproxy_bytes_total{direction=\"server_to_client\"} 5\n",
+		"\nproxy_upstream_failures_total 1\n",
+		"\nproxy_upstream_dial_failures_total{upstream=\"a:2101\"} 1\n" +
+			"proxy_upstream_dial_failures_total{upstream=\"b:2101\"} 2\n",
+		"\nproxy_upstream_connections_open{upstream=\"a:2101\"} 0\n" +
+			"proxy_upstream_connections_open{upstream=\"b:2101\"} 1\n",
+		"\nproxy_upstream_connections_total{upstream=\"b:2101\"} 1\n",
+		"\nproxy_upstream_healthy{upstream=\"a:2101\"} 0\n" +
+			"proxy_upstream_healthy{upstream=\"b:2101\"} 1\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Expected the metrics to contain %q, got\n%s", want, b.String())
+		}
+	}
+}
+
+// TestEvents tests the events published by the feed.
+func TestEvents(t *testing.T) {
+	clientBuffer := []byte("foo")
+
+	log := logger.New()
+	reportFeed := New(log)
+	events, cancel := reportFeed.Subscribe()
+	defer cancel()
+
+	reportFeed.RecordConnectionOpened(3, "192.168.1.2:4242")
+	reportFeed.RecordUpstream(3, "caster.example.com:2101")
+	reportFeed.RecordClientBuffer(&clientBuffer, 3, 2)
+	reportFeed.RecordConnectionClosed(3)
+	reportFeed.RecordUpstreamFailure(4, errors.New("connection refused"))
+	reportFeed.RecordUpstreamHealth("caster.example.com:2101", false)
+
+	expected := []statusreporter.Event{
+		{Type: "connection", Data: ConnectionEvent{"opened", 3, "192.168.1.2:4242"}},
+		{Type: "connection", Data: ConnectionEvent{"connected", 3, "caster.example.com:2101"}},
+		{Type: "buffer", Data: BufferEvent{"client_to_server", 3, 2}},
+		{Type: "connection", Data: ConnectionEvent{"closed", 3, ""}},
+		{Type: "upstream", Data: UpstreamFailureEvent{4, "connection refused"}},
+		{Type: "health", Data: UpstreamHealthEvent{"caster.example.com:2101", false}},
+	}
+	for _, want := range expected {
+		got := <-events
+		if got.Type != want.Type || got.Data != want.Data {
+			t.Errorf("Expected event %+v, got %+v", want, got)
+		}
+	}
+}
@@ -1,23 +1,40 @@
 package reportfeed
 
-// reportFormat defines the HTML structure of the report.
+// reportFormat defines the HTML structure of the report.  The first argument is a prefix for the element
+// ids, so that the reports of several routes can share a page.
 const reportFormat = `
 <h3>Last Client Buffer</h3>
-<span id='clienttimestamp'>%s</span>
+<span id='%[1]sclienttimestamp'>%[2]s</span>
 <pre>
 <code>
-<div class="preformatted" id='clientbuffer'>
-%s
+<div class="preformatted" id='%[1]sclientbuffer'>
+%[3]s
 </div>
 </code>
 </pre>
 <h3>Last Server Buffer</h3>
-<span id='servertimestamp'>%s</span>
+<span id='%[1]sservertimestamp'>%[4]s</span>
 <pre>
 <code>
-<div class="preformatted" id='serverbuffer'>
-%s
+<div class="preformatted" id='%[1]sserverbuffer'>
+%[5]s
 </div>
 </code>
 </pre>
-`
\ No newline at end of file
+<h3>Connections</h3>
+<div id='%[1]sconnections'>
+%[6]s
+</div>
+<h3>Upstreams</h3>
+<div id='%[1]supstreams'>
+%[7]s
+</div>
+`
+
+// routeFormat defines the HTML structure of the section of the report for one route.
+const routeFormat = `
+<h2>Route %s</h2>
+<div class="route" id='route-%s'>
+%s
+</div>
+`
@@ -0,0 +1,180 @@
+package reportfeed
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/goblimey/go-tools/logger"
+	"github.com/goblimey/go-tools/statusreporter"
+)
+
+// subscriberBufferSize is the number of route events that can wait for a slow subscriber.
+const subscriberBufferSize = 64
+
+// Route is the report feed of one of several proxies that share a status reporter.
+type Route struct {
+	Name       string
+	Feed       *ReportFeed
+	FixedLevel bool // If true, the route has its own log level, which SetLogLevel leaves alone.
+}
+
+// RouteReport is the structured status report of one route.
+type RouteReport struct {
+	Name string `json:"name"`
+	StatusReport
+}
+
+// RouteEvent is the data of an event published by one of the routes.  The event keeps its type.
+type RouteEvent struct {
+	Route string      `json:"route"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// RoutesFeed combines the report feeds of several proxies so that they can share a status reporter.  The
+// status report has a section for each route, the metrics and the events are labelled with the route and
+// the health checks are named after it.  Health checks that aren't about a particular route can be added to
+// the RoutesFeed itself.
+type RoutesFeed struct {
+	statusreporter.HealthRegistry
+	routes []Route
+}
+
+// RoutesFeed implements the same interfaces as ReportFeed.
+var (
+	_ statusreporter.ReportFeedT          = (*RoutesFeed)(nil)
+	_ statusreporter.StructuredReportFeed = (*RoutesFeed)(nil)
+	_ statusreporter.MetricsFeed          = (*RoutesFeed)(nil)
+	_ statusreporter.EventFeed            = (*RoutesFeed)(nil)
+	_ statusreporter.LogLevelFeed         = (*RoutesFeed)(nil)
+	_ statusreporter.HealthFeed           = (*RoutesFeed)(nil)
+)
+
+// NewRoutesFeed creates and returns a RoutesFeed for the given routes, which are reported in that order.
+func NewRoutesFeed(routes ...Route) *RoutesFeed {
+	return &RoutesFeed{routes: routes}
+}
+
+// Routes returns the routes.
+func (rf *RoutesFeed) Routes() []Route {
+	return rf.routes
+}
+
+// SetLogLevel satisfies the ReportFeedT interface.  It sets the log level of every route except those with
+// a FixedLevel.  Routes often share a logger, so the level is set once on each distinct logger.
+func (rf *RoutesFeed) SetLogLevel(level uint8) {
+	done := make(map[*logger.LoggerT]bool)
+	for _, route := range rf.routes {
+		if route.FixedLevel || done[route.Feed.logger] {
+			continue
+		}
+		done[route.Feed.logger] = true
+		route.Feed.SetLogLevel(level)
+	}
+}
+
+// GetLogLevel satisfies the LogLevelFeed interface.  It returns the highest log level of the routes.
+func (rf *RoutesFeed) GetLogLevel() uint8 {
+	var level uint8
+	for _, route := range rf.routes {
+		if l := route.Feed.GetLogLevel(); l > level {
+			level = l
+		}
+	}
+	return level
+}
+
+// Status satisfies the ReportFeedT interface.  The element ids in each route's section start with the name
+// of the route, eg "casters-clientbuffer", so that they are unique in the page.
+func (rf *RoutesFeed) Status() []byte {
+	var b strings.Builder
+	for _, route := range rf.routes {
+		name := Sanitise(route.Name)
+		fmt.Fprintf(&b, routeFormat, name, name, route.Feed.status(name+"-"))
+	}
+	return []byte(b.String())
+}
+
+// StructuredStatus satisfies the StructuredReportFeed interface.  It returns a RouteReport for each route.
+func (rf *RoutesFeed) StructuredStatus() interface{} {
+	reports := make([]RouteReport, 0, len(rf.routes))
+	for _, route := range rf.routes {
+		reports = append(reports,
+			RouteReport{Name: route.Name, StatusReport: route.Feed.StructuredStatus().(StatusReport)})
+	}
+	return reports
+}
+
+// Metrics satisfies the MetricsFeed interface.  Each route's metrics are labelled with its name.
+func (rf *RoutesFeed) Metrics() []statusreporter.Metric {
+	var metrics []statusreporter.Metric
+	for _, route := range rf.routes {
+		for _, metric := range route.Feed.Metrics() {
+			labels := map[string]string{"route": route.Name}
+			for name, value := range metric.Labels {
+				labels[name] = value
+			}
+			metric.Labels = labels
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics
+}
+
+// HealthChecks satisfies the HealthFeed interface.  It runs the checks added to the RoutesFeed and then
+// the checks of each route, named "{route}: {check}".
+func (rf *RoutesFeed) HealthChecks() []statusreporter.Check {
+	checks := rf.HealthRegistry.HealthChecks()
+	for _, route := range rf.routes {
+		for _, check := range route.Feed.HealthChecks() {
+			check.Name = route.Name + ": " + check.Name
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
+
+// Subscribe satisfies the EventFeed interface.  It merges the events of the routes, wrapping the data of
+// each in a RouteEvent.  As with a single feed, events are dropped if the subscriber falls behind.
+func (rf *RoutesFeed) Subscribe() (<-chan statusreporter.Event, func()) {
+	events := make(chan statusreporter.Event, subscriberBufferSize)
+	done := make(chan struct{})
+	var forwarders sync.WaitGroup
+	var cancels []func()
+	for _, route := range rf.routes {
+		routeEvents, cancel := route.Feed.Subscribe()
+		cancels = append(cancels, cancel)
+		forwarders.Add(1)
+		go func(name string, routeEvents <-chan statusreporter.Event) {
+			defer forwarders.Done()
+			for {
+				select {
+				case <-done:
+					return
+				case event, ok := <-routeEvents:
+					if !ok {
+						return
+					}
+					event.Data = RouteEvent{name, event.Data}
+					select {
+					case events <- event:
+					default:
+					}
+				}
+			}
+		}(route.Name, routeEvents)
+	}
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+			for _, cancel := range cancels {
+				cancel()
+			}
+			forwarders.Wait()
+			close(events)
+		})
+	}
+	return events, cancel
+}
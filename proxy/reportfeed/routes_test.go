@@ -0,0 +1,173 @@
+package reportfeed
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/logger"
+	"github.com/goblimey/go-tools/statusreporter"
+)
+
+// TestRoutesStatus tests that each route gets its own section of the status reports.
+func TestRoutesStatus(t *testing.T) {
+	casters := New(logger.New())
+	casters.RecordConnectionOpened(1, "192.168.1.2:4242")
+	web := New(logger.New())
+	routesFeed := NewRoutesFeed(Route{"casters", casters, false}, Route{"web", web, false})
+
+	status := string(routesFeed.Status())
+	first := strings.Index(status, "<h2>Route casters</h2>")
+	second := strings.Index(status, "<h2>Route web</h2>")
+	if first < 0 || second < first {
+		t.Fatalf("Expected sections for casters and then web, got \"%s\"", status)
+	}
+	for _, id := range []string{"'casters-clientbuffer'", "'casters-connections'", "'web-clientbuffer'",
+		"'web-upstreams'"} {
+
+		if strings.Count(status, id) != 1 {
+			t.Errorf("Expected the id %s once, got \"%s\"", id, status)
+		}
+	}
+	if strings.Contains(status, "id='clientbuffer'") {
+		t.Errorf("Expected the ids to be prefixed with the route, got \"%s\"", status)
+	}
+	if !strings.Contains(status[first:second], "192.168.1.2:4242") ||
+		!strings.Contains(status[second:], "no open connections") {
+
+		t.Errorf("Expected the connection in the casters section only, got \"%s\"", status)
+	}
+
+	reports := routesFeed.StructuredStatus().([]RouteReport)
+	if len(reports) != 2 || reports[0].Name != "casters" || len(reports[0].Connections) != 1 ||
+		reports[1].Name != "web" || len(reports[1].Connections) != 0 {
+
+		t.Errorf("Expected casters with one connection and web with none, got %+v", reports)
+	}
+}
+
+// TestRoutesMetrics tests that the metrics are labelled with the route.
+func TestRoutesMetrics(t *testing.T) {
+	casters := New(logger.New())
+	casters.RecordConnectionOpened(1, "foo")
+	web := New(logger.New())
+	web.RecordUpstreamFailure(1, errors.New("connection refused"))
+	routesFeed := NewRoutesFeed(Route{"casters", casters, false}, Route{"web", web, false})
+
+	var b strings.Builder
+	err := statusreporter.WriteMetrics(&b, routesFeed.Metrics())
+	if err != nil {
+		t.Fatalf("WriteMetrics failed - %v", err)
+	}
+	for _, want := range []string{
+		"\nproxy_connections_total{route=\"casters\"} 1\n" +
+			"proxy_connections_total{route=\"web\"} 0\n",
+		"\nproxy_bytes_total{direction=\"client_to_server\",route=\"casters\"} 0\n",
+		"\nproxy_upstream_failures_total{route=\"casters\"} 0\n" +
+			"proxy_upstream_failures_total{route=\"web\"} 1\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("Expected the metrics to contain %q, got\n%s", want, b.String())
+		}
+	}
+}
+
+// TestRoutesHealthAndLogLevel tests that the health checks are named after the route and that the log
+// level is set for every route.
+func TestRoutesHealthAndLogLevel(t *testing.T) {
+	// Log to nowhere, so that enabling the log doesn't create a file.
+	casters := New(logger.New(logger.ToWriter(ioutil.Discard)))
+	casters.AddHealthCheck("listener up", false, func() error { return nil })
+	web := New(logger.New(logger.ToWriter(ioutil.Discard)))
+	web.AddHealthCheck("listener up", false, func() error { return errors.New("not listening") })
+	routesFeed := NewRoutesFeed(Route{"casters", casters, false}, Route{"web", web, false})
+	routesFeed.AddHealthCheck("log directory writable", true, func() error { return nil })
+
+	checks := routesFeed.HealthChecks()
+	expected := []statusreporter.Check{
+		{Name: "log directory writable", OK: true, ReadinessOnly: true},
+		{Name: "casters: listener up", OK: true},
+		{Name: "web: listener up", OK: false, Message: "not listening"},
+	}
+	if len(checks) != len(expected) {
+		t.Fatalf("Expected checks %+v, got %+v", expected, checks)
+	}
+	for i := range expected {
+		if checks[i] != expected[i] {
+			t.Errorf("Expected check %+v, got %+v", expected[i], checks[i])
+		}
+	}
+
+	routesFeed.SetLogLevel(1)
+	if casters.GetLogLevel() != 1 || web.GetLogLevel() != 1 || routesFeed.GetLogLevel() != 1 {
+		t.Errorf("Expected log level 1 everywhere, got %d, %d and %d",
+			casters.GetLogLevel(), web.GetLogLevel(), routesFeed.GetLogLevel())
+	}
+}
+
+// TestRoutesFixedLevel tests that setting the log level leaves a route with a fixed level alone.
+func TestRoutesFixedLevel(t *testing.T) {
+	casters := New(logger.New(logger.ToWriter(ioutil.Discard)))
+	web := New(logger.New(logger.ToWriter(ioutil.Discard)))
+	web.SetLogLevel(2)
+	routesFeed := NewRoutesFeed(Route{"casters", casters, false}, Route{"web", web, true})
+
+	routesFeed.SetLogLevel(1)
+	if casters.GetLogLevel() != 1 || web.GetLogLevel() != 2 {
+		t.Errorf("Expected log levels 1 and 2, got %d and %d", casters.GetLogLevel(), web.GetLogLevel())
+	}
+}
+
+// TestRoutesSharedLogger tests that the log level of a logger shared by several routes is set once.
+func TestRoutesSharedLogger(t *testing.T) {
+	// The log can't be opened, so each attempt to set the level writes an error to stderr.
+	shared := logger.New(logger.ToFile(filepath.Join("nonexistent", "test.log")))
+	routesFeed := NewRoutesFeed(Route{"casters", New(shared), false}, Route{"web", New(shared), false})
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("cannot create a pipe - %v", err)
+	}
+	defer r.Close()
+	stderr := os.Stderr
+	os.Stderr = w
+	routesFeed.SetLogLevel(1)
+	os.Stderr = stderr
+	w.Close()
+
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("cannot read the pipe - %v", err)
+	}
+	if strings.Count(string(contents), "cannot set log level") != 1 {
+		t.Errorf("Expected one attempt to set the level, got \"%s\"", contents)
+	}
+}
+
+// TestRoutesEvents tests that the events of the routes are merged and labelled with the route.
+func TestRoutesEvents(t *testing.T) {
+	casters := New(logger.New())
+	web := New(logger.New())
+	routesFeed := NewRoutesFeed(Route{"casters", casters, false}, Route{"web", web, false})
+	events, cancel := routesFeed.Subscribe()
+
+	web.RecordConnectionOpened(3, "192.168.1.2:4242")
+	select {
+	case got := <-events:
+		want := RouteEvent{"web", ConnectionEvent{"opened", 3, "192.168.1.2:4242"}}
+		if got.Type != "connection" || got.Data != want {
+			t.Errorf("Expected a connection event %+v, got %+v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event received")
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("Expected the events channel to be closed")
+	}
+}
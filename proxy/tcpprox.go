@@ -1,18 +1,18 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"encoding/hex"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/goblimey/go-tools/logger"
-	reportfeed "github.com/goblimey/go-tools/proxy/reportfeed"
+	"github.com/goblimey/go-tools/proxy/tcpproxy"
 	reporter "github.com/goblimey/go-tools/statusreporter"
 )
 
@@ -30,14 +30,21 @@ import (
 // initially by options and at runtime by sending HTTP requests:
 //    /status/loglevel/0
 //    /status/loglevel/1
+//    /status/loglevel/3
+//
+// Level 1 logs connection events, level 3 also logs a hex dump of every
+// buffer.  Verbose logging is level 3.
 //
 // The /status/report request displays the timestamp and contents of the last
 // input and output buffers.
+//
+// The proxy itself is in the tcpproxy package.  This program sets it up from
+// the command line and runs it with a status reporter.  A config file can
+// describe several routes, each run by its own proxy, sharing the reporter.
+// tcpproxy.Routes builds the proxies, their health checks and the commands.
 
 var log *logger.LoggerT
 
-var reportFeed *reportfeed.ReportFeed
-
 func init() {
 	log = logger.New()
 }
@@ -46,13 +53,24 @@ func main() {
 	// Handle command line arguments.
 	localPortPtr := flag.Int("p", 0, "Local Port to listen on")
 	localHostPtr := flag.String("l", "", "Local address to listen on")
-	remoteHostPtr := flag.String("r", "", "Remote Server address host:port")
+	remoteHostPtr := flag.String("r", "", "Remote Server address host:port, or a comma-separated list to try in turn")
 	configFilePtr := flag.String("c", "", "Use a config file (set TLS ect) - Commandline params overwrite config file")
 	tlsPtr := flag.Bool("s", false, "Create a TLS Proxy")
 	certFilePtr := flag.String("cert", "", "Use a specific certificate file")
 
 	controlHostPtr := flag.String("ca", "localhost", "hostname to listen on for status requests")
 	controlPortPtr := flag.Int("cp", 8080, "port to listen on for status requests")
+	controlTLSPtr := flag.Bool("cs", false, "serve status requests over HTTPS")
+	controlCertFilePtr := flag.String("ccert", "", "certificate file for status requests, default as -cert")
+	controlHtpasswdPtr := flag.String("chtpasswd", "", "htpasswd file of users allowed to make status requests")
+	controlTokensPtr := flag.String("ctokens", "", "file of bearer tokens allowed to make status requests")
+	historySizePtr := flag.Int("chistory", 0, "number of status snapshots to keep in the history, 0 for none")
+	historyIntervalPtr := flag.Duration("chistoryinterval", time.Minute, "interval between status snapshots")
+	historyFilePtr := flag.String("chistoryfile", "", "file to keep the status history in across restarts")
+
+	logFilePtr := flag.String("log", "./log.txt", "file to write the verbose log to, \"-\" for stderr")
+	logDailyPtr := flag.Bool("logdaily", false, "rotate the verbose log daily, adding a datestamp to the file name")
+	logJSONPtr := flag.Bool("logjson", false, "write the verbose log as JSON lines")
 
 	verbose := false
 	flag.BoolVar(&verbose, "v", true, "verbose logging (shorthand)")
@@ -64,165 +82,148 @@ func main() {
 
 	flag.Parse()
 
-	localPort := *localPortPtr     // Local Port to listen on.
-	localHost := *localHostPtr     // Local address to listen on.
-	remoteHost := *remoteHostPtr   // Remote Server address host:port.
-	certFile := *certFilePtr       // cert file to support https.
-	configFile := *configFilePtr   // Config file for TLS connection.
-	controlHost := *controlHostPtr // Hostname for status requests
-	controlPort := *controlPortPtr // Port for status requests.
-	isTLS := *tlsPtr               // If true, offer HTTPS, otherwise http.
+	localPort := *localPortPtr             // Local Port to listen on.
+	localHost := *localHostPtr             // Local address to listen on.
+	remoteHost := *remoteHostPtr           // Remote Server addresses host:port.
+	certFile := *certFilePtr               // cert file to support https.
+	configFile := *configFilePtr           // Config file for TLS connection.
+	controlHost := *controlHostPtr         // Hostname for status requests
+	controlPort := *controlPortPtr         // Port for status requests.
+	controlTLS := *controlTLSPtr           // If true, serve status requests over HTTPS.
+	controlCertFile := *controlCertFilePtr // cert file for status requests.
+	controlHtpasswd := *controlHtpasswdPtr // Users allowed to make status requests.
+	controlTokens := *controlTokensPtr     // Tokens allowed to make status requests.
+	isTLS := *tlsPtr                       // If true, offer HTTPS, otherwise http.
+	logFile := *logFilePtr                 // Where the verbose log goes.
+	logDaily := *logDailyPtr               // If true, rotate the verbose log daily.
+	logJSON := *logJSONPtr                 // If true, write the verbose log as JSON lines.
+	history := reporter.HistoryConfig{
+		Interval: *historyIntervalPtr, // Interval between status snapshots.
+		Size:     *historySizePtr,     // Number of snapshots kept, 0 for no history.
+		File:     *historyFilePtr,     // File to keep the history in.
+	}
+
+	logDestination, dailyLog, err := tcpproxy.LogDestination(logFile, logDaily)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[-] cannot create log - %s\n", err.Error())
+		os.Exit(1)
+	}
+	logOptions := []logger.Option{logDestination}
+	if logJSON {
+		logOptions = append(logOptions, logger.WithJSON())
+	}
+	log = logger.New(logOptions...)
 
 	// Set up the logging.  It should be either quiet or verbose.
+	logLevel := uint8(0)
 	if verbose {
-		log.SetLogLevel(1)
+		logLevel = logger.TraceLevel
 	}
 	if quiet {
-		log.SetLogLevel(0) // quiet trumps verbose.
+		logLevel = 0 // quiet trumps verbose.
 	}
-
-	// Set up the status reporter and the proxy server
-
-	fmt.Fprintf(log, "setting up status reporter")
-	SetReportFeed(makeReporter(controlHost, controlPort))
-
-	fmt.Fprintf(log, "setting up routes\n")
-
-	SetConfig(configFile, localPort, localHost, remoteHost, certFile)
-
-	if config.Remotehost == "" {
-		fmt.Fprintf(os.Stderr, "[x] Remote host required")
-		flag.PrintDefaults()
-		os.Exit(1)
+	err = log.SetLogLevel(logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[-] %s - continuing without a log\n", err.Error())
 	}
 
-	// Start the main server for NTRIP traffic.
-	StartClientListener(isTLS)
-}
-
-// SetReportFeed sets the
-func SetReportFeed(feed *reportfeed.ReportFeed) {
-	reportFeed = feed
-}
-
-// StartClientListener starts listening for traffic from the client.
-func StartClientListener(isTLS bool) {
-
-	client := connectToClient(isTLS)
-	defer func() { client.Close() }()
+	// Set up the proxy server and the status reporter
 
-	fmt.Fprintf(log, "[*] Listening for Client call ...\n")
+	log.Debugf("setting up routes")
 
-	for {
-		call, err := client.Accept()
+	// The config file can describe several routes.  Otherwise there's one
+	// proxy, set up from the command line and the config file.
+	var routes []tcpproxy.Route
+	if configFile != "" {
+		routes, err = tcpproxy.ReadRoutes(configFile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to accept call from client: %s\n", err)
-			break
+			fmt.Fprintf(os.Stderr, "[-] Not a valid config file: %s\n", err.Error())
+			os.Exit(1)
 		}
-		id := ids
-		ids++
-		fmt.Fprintf(log, "[*][%d]connection Accepted from: client %s\n", id, call.RemoteAddr())
-
-		server := connectToServer(isTLS)
-		fmt.Fprintf(log, "[*][%d] Connected to server: %s\n", id, server.RemoteAddr())
-
-		go handleMessages(server, call, isTLS, id)
 	}
-}
-
-func connectToClient(isTLS bool) (conn net.Listener) {
-	var err error
-
-	if isTLS == true {
-		conn, err = tlsListen()
+	if routes != nil {
+		if localPort != 0 || localHost != "" || remoteHost != "" || certFile != "" || isTLS {
+			fmt.Fprintf(os.Stderr, "[x] -p, -l, -r, -cert and -s can't be used with a config file that has routes\n")
+			os.Exit(1)
+		}
 	} else {
-		fmt.Fprintf(log, "listening on %s\n", fmt.Sprint(config.Localhost, ":", config.Localport))
-		conn, err = net.Listen("tcp", fmt.Sprint(config.Localhost, ":", config.Localport))
-	}
+		config := makeConfig(configFile, localPort, localHost, remoteHost, certFile, isTLS)
 
-	if err != nil {
-		panic("failed to connect to client: " + err.Error())
-	}
-
-	return conn
-}
-
-func connectToServer(isTLS bool) (conn net.Conn) {
-	var err error
-
-	if isTLS == true {
-		conf := tls.Config{InsecureSkipVerify: true}
-		conn, err = tls.Dial("tcp", config.Remotehost, &conf)
-	} else {
-		conn, err = net.Dial("tcp", config.Remotehost)
+		if len(config.Upstreams()) == 0 {
+			fmt.Fprintf(os.Stderr, "[x] Remote host required")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+		err = config.Validate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[x] %v\n", err)
+			os.Exit(1)
+		}
+		routes = []tcpproxy.Route{{Config: config}}
 	}
 
+	log.Debugf("setting up status reporter")
+	authenticators, err := makeAuthenticators(controlHtpasswd, controlTokens)
 	if err != nil {
-		panic("failed to connect to server: " + err.Error())
+		fmt.Fprintf(os.Stderr, "[-] cannot set up authentication for status requests - %s\n", err.Error())
+		os.Exit(1)
 	}
-	return conn
-}
-
-func handleMessages(server, client net.Conn, isTLS bool, id int) {
-
-	// Next bit needs coordination?
-	go handleServerMessages(server, client, id)
-	handleClientMessages(server, client, id)
-	server.Close()
-	client.Close()
-}
-
-func handleClientMessages(server, client net.Conn, id int) {
-	for {
-		data := make([]byte, 2048)
-		n, err := client.Read(data)
-		if n > 0 {
-			fmt.Fprintf(log, "From Client [%d]:\n%s\n", id, hex.Dump(data[:n]))
-			//fmt.Fprintf("From Client:\n%s\n",hex.EncodeToString(data[:n]))
-			// Hang onto the buffer for reporting until the next one arrives
-			reportFeed.RecordClientBuffer(&data, uint64(id), n)
-			server.Write(data[:n])
+	var controlTLSConfig *tls.Config
+	if controlTLS {
+		if controlCertFile == "" {
+			controlCertFile = routes[0].CertFile
 		}
-		if err != nil && err == io.EOF { // INCONSISTENT?
-			fmt.Println(err)
-			return
+		cert, err := tcpproxy.LoadCertificate(controlCertFile, routes[0].TLS)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[-] cannot load certificate for status requests - %s\n", err.Error())
+			os.Exit(1)
 		}
+		controlTLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
 	}
-}
 
-func handleServerMessages(server, client net.Conn, id int) {
-	for {
-		data := make([]byte, 2048)
-		n, err := server.Read(data)
-		if n > 0 {
-			fmt.Fprintf(log, "From Server [%d]:\n%s\n", id, hex.Dump(data[:n]))
-			//fmt.Fprintf("From Server:\n%s\n",hex.EncodeToString(data[:n]))
-			// Hang onto the buffer for reporting until the next one arrives
-			reportFeed.RecordServerBuffer(&data, uint64(id), n)
-			client.Write(data[:n])
-		}
-		if err != nil && err != io.EOF { // INCONSISTENT?
-			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
-			break
-		}
+	running, err := tcpproxy.NewRoutes(routes, log, logLevel, logFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[-] cannot set up the proxy - %s\n", err.Error())
+		os.Exit(1)
+	}
+	startReporter(running, controlHost, controlPort, authenticators, controlTLSConfig, history)
+
+	// Stop cleanly on an interrupt or a termination signal.
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	// Start the main servers for NTRIP traffic.  If one fails, give up.
+	err = running.Start(ctx)
+	running.Close()
+	log.Close()
+	if dailyLog != nil {
+		dailyLog.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[-] %s\n", err.Error())
+		os.Exit(1)
 	}
 }
 
-// SetConfig sets the proxy config - the server for which it acts as a proxy etc.
-func SetConfig(configFile string, localPort int, localHost, remoteHost string, certFile string) {
+// makeConfig returns the proxy config - the server for which it acts as a
+// proxy etc - read from the config file, if there is one.  The other
+// arguments override the file.
+func makeConfig(configFile string, localPort int, localHost, remoteHost string, certFile string,
+	isTLS bool) tcpproxy.Config {
+
+	config := tcpproxy.Config{TLS: &tcpproxy.TLS{}}
 	if configFile != "" {
-		data, err := ioutil.ReadFile(configFile)
+		var err error
+		config, err = tcpproxy.ReadConfig(configFile)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "[-] Not a valid config file: %s\n", err.Error())
 			os.Exit(1)
 		}
-		err = json.Unmarshal(data, &config)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "[-] Not a valid config file: %s\n", err.Error())
-			os.Exit(1)
-		}
-	} else {
-		config = Config{TLS: &TLS{}}
 	}
 
 	if certFile != "" {
@@ -236,21 +237,60 @@ func SetConfig(configFile string, localPort int, localHost, remoteHost string, c
 		config.Localhost = localHost
 	}
 	if remoteHost != "" {
-		config.Remotehost = remoteHost
+		config.Remotehosts = strings.Split(remoteHost, ",")
+	}
+	if isTLS {
+		config.IsTLS = true
+	}
+	return config
+}
+
+// makeAuthenticators returns the authenticators for the status requests, read
+// from an htpasswd file and a file of bearer tokens.  Either name can be "".
+// Users and tokens without a list of roles may make all requests.
+func makeAuthenticators(htpasswdFile, tokensFile string) ([]reporter.Authenticator, error) {
+	var authenticators []reporter.Authenticator
+	if htpasswdFile != "" {
+		auth, err := reporter.LoadHtpasswd(htpasswdFile, reporter.RoleRead, reporter.RoleControl)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, auth)
 	}
+	if tokensFile != "" {
+		auth, err := reporter.LoadBearerTokens(tokensFile, reporter.RoleRead, reporter.RoleControl)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, auth)
+	}
+	return authenticators, nil
 }
 
-func makeReporter(controlHost string, controlPort int) *reportfeed.ReportFeed {
-	fmt.Fprintf(log, "setting up the status reporter\n")
+// startReporter starts the status reporter for the routes.
+func startReporter(routes *tcpproxy.Routes, controlHost string, controlPort int,
+	authenticators []reporter.Authenticator, tlsConfig *tls.Config, history reporter.HistoryConfig) {
 
-	rf := reportfeed.New(log)
+	log.Debugf("setting up the status reporter")
 
-	proxyReporter := reporter.MakeReporter(rf, controlHost, controlPort)
+	proxyReporter := reporter.MakeReporter(routes.ReportFeed(), controlHost, controlPort)
 
 	proxyReporter.SetUseTextTemplates(true)
+	proxyReporter.SetAuthenticators(authenticators...)
+	proxyReporter.SetTLSConfig(tlsConfig)
+	for _, command := range routes.Commands() {
+		err := proxyReporter.RegisterCommand(command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[-] %s\n", err.Error())
+		}
+	}
+	if history.Size > 0 {
+		err := proxyReporter.StartHistory(history)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[-] cannot start the status history - %s\n", err.Error())
+		}
+	}
 
 	// Start the HTTP server for control requests.
 	go proxyReporter.StartService()
-
-	return rf
 }
@@ -0,0 +1,169 @@
+package tcpproxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/goblimey/go-tools/logger"
+)
+
+// The strategies for spreading connections across the servers.
+const (
+	// BalanceFailover tries the servers in the order they are listed.
+	BalanceFailover = "failover"
+	// BalanceRoundRobin starts each connection at the next server in the list.
+	BalanceRoundRobin = "round-robin"
+	// BalanceLeastConnections starts each connection at the server with the fewest open connections.
+	BalanceLeastConnections = "least-connections"
+	// BalanceSourceIPHash starts each connection at a server chosen by the client's IP address, so a
+	// client keeps using the same server while the set of healthy servers stays the same.
+	BalanceSourceIPHash = "source-ip-hash"
+)
+
+// The defaults for the HealthCheck settings.
+const (
+	DefaultHealthCheckTimeout = 2 * time.Second
+	DefaultUnhealthyThreshold = 2
+	DefaultHealthyThreshold   = 1
+)
+
+// HealthCheck controls the active health checks.  If Interval is set, the
+// proxy tries to connect to each server at that interval.  A server that
+// fails UnhealthyThreshold checks in a row is taken out of rotation until it
+// passes HealthyThreshold checks in a row.  Zero values mean the defaults.
+type HealthCheck struct {
+	Interval           Duration
+	Timeout            Duration
+	UnhealthyThreshold int
+	HealthyThreshold   int
+}
+
+// withDefaults returns the settings with the defaults filled in.
+func (h HealthCheck) withDefaults() HealthCheck {
+	if h.Timeout <= 0 {
+		h.Timeout = Duration(DefaultHealthCheckTimeout)
+	}
+	if h.UnhealthyThreshold <= 0 {
+		h.UnhealthyThreshold = DefaultUnhealthyThreshold
+	}
+	if h.HealthyThreshold <= 0 {
+		h.HealthyThreshold = DefaultHealthyThreshold
+	}
+	return h
+}
+
+// validBalance returns an error if the balance strategy is not known.
+func validBalance(balance string) error {
+	switch balance {
+	case "", BalanceFailover, BalanceRoundRobin, BalanceLeastConnections, BalanceSourceIPHash:
+		return nil
+	default:
+		return fmt.Errorf("unknown balance strategy \"%s\"", balance)
+	}
+}
+
+// order returns the servers in the order that they should be tried for a
+// connection from the given client.  Servers that have failed their health
+// checks are left out, unless they all have.
+func (p *Proxy) order(client net.Addr) []*upstream {
+	var healthy []*upstream
+	for _, u := range p.upstreams {
+		if !u.unhealthy {
+			healthy = append(healthy, u)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = append(healthy, p.upstreams...)
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	start := 0
+	switch p.config.Balance {
+	case BalanceRoundRobin:
+		start = int(p.nextUpstream % uint64(len(healthy)))
+		p.nextUpstream++
+	case BalanceLeastConnections:
+		// Fewest connections first.  Servers with the same number stay in the order they are listed.
+		sort.SliceStable(healthy, func(i, j int) bool { return healthy[i].active < healthy[j].active })
+	case BalanceSourceIPHash:
+		host := client.String()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		hash := fnv.New32a()
+		hash.Write([]byte(host))
+		start = int(hash.Sum32() % uint32(len(healthy)))
+	}
+
+	// Try the chosen server first and then the others in turn.
+	return append(healthy[start:], healthy[:start]...)
+}
+
+// checkHealth checks the servers at the interval given in the config until
+// the proxy is stopped.  It should be run in a goroutine.
+func (p *Proxy) checkHealth() {
+	defer p.handlers.Done()
+
+	settings := p.config.HealthCheck.withDefaults()
+	ticker := p.clock.NewTicker(time.Duration(settings.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopped:
+			return
+		case <-ticker.C():
+			for _, u := range p.upstreams {
+				p.checkUpstream(u, settings)
+			}
+		}
+	}
+}
+
+// checkUpstream tries to connect to a server and takes it out of rotation or
+// puts it back if it has failed or passed enough checks in a row.
+func (p *Proxy) checkUpstream(u *upstream, settings HealthCheck) {
+	dialer := net.Dialer{Timeout: time.Duration(settings.Timeout)}
+	conn, err := dialer.DialContext(p.ctx, "tcp", u.address)
+	if conn != nil {
+		conn.Close()
+	}
+	if p.ctx.Err() != nil {
+		return
+	}
+
+	p.mutex.Lock()
+	changed := false
+	if err == nil {
+		u.checkFailures = 0
+		u.checkPasses++
+		if u.unhealthy && u.checkPasses >= settings.HealthyThreshold {
+			u.unhealthy = false
+			changed = true
+		}
+	} else {
+		u.checkPasses = 0
+		u.checkFailures++
+		if !u.unhealthy && u.checkFailures >= settings.UnhealthyThreshold {
+			u.unhealthy = true
+			changed = true
+		}
+	}
+	healthy := !u.unhealthy
+	p.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+	if healthy {
+		p.log.Info("[*] upstream is healthy again", logger.F("upstream", u.address))
+	} else {
+		p.log.Error("[-] upstream failed its health checks", logger.F("upstream", u.address),
+			logger.F("error", err.Error()))
+	}
+	p.feed.RecordUpstreamHealth(u.address, healthy)
+}
@@ -0,0 +1,123 @@
+package tcpproxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+)
+
+// TestOrder checks the order in which each balance strategy tries the servers.
+func TestOrder(t *testing.T) {
+	client := &net.TCPAddr{IP: net.ParseIP("192.168.1.2"), Port: 4242}
+	addresses := func(upstreams []*upstream) []string {
+		var result []string
+		for _, u := range upstreams {
+			result = append(result, u.address)
+		}
+		return result
+	}
+	equal := func(a, b []string) bool {
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if a[i] != b[i] {
+				return false
+			}
+		}
+		return true
+	}
+	makeProxy := func(balance string) *Proxy {
+		return New(Config{Remotehosts: []string{"a:1", "b:1", "c:1"}, Balance: balance})
+	}
+
+	p := makeProxy(BalanceFailover)
+	for i := 0; i < 2; i++ {
+		if got := addresses(p.order(client)); !equal(got, []string{"a:1", "b:1", "c:1"}) {
+			t.Errorf("failover: expected the servers in order, got %v", got)
+		}
+	}
+
+	p = makeProxy(BalanceRoundRobin)
+	for _, want := range [][]string{{"a:1", "b:1", "c:1"}, {"b:1", "c:1", "a:1"}, {"c:1", "a:1", "b:1"}} {
+		if got := addresses(p.order(client)); !equal(got, want) {
+			t.Errorf("round-robin: expected %v, got %v", want, got)
+		}
+	}
+
+	p = makeProxy(BalanceLeastConnections)
+	p.upstreams[0].active = 2
+	p.upstreams[1].active = 1
+	if got := addresses(p.order(client)); !equal(got, []string{"c:1", "b:1", "a:1"}) {
+		t.Errorf("least-connections: expected c, b, a, got %v", got)
+	}
+
+	// The same client gets the same server whatever its port.
+	p = makeProxy(BalanceSourceIPHash)
+	first := p.order(client)[0]
+	other := &net.TCPAddr{IP: client.IP, Port: 4343}
+	if p.order(other)[0] != first {
+		t.Errorf("source-ip-hash: expected %s for both ports", first.address)
+	}
+
+	// Unhealthy servers are left out unless they all are.
+	p = makeProxy(BalanceFailover)
+	p.upstreams[0].unhealthy = true
+	if got := addresses(p.order(client)); !equal(got, []string{"b:1", "c:1"}) {
+		t.Errorf("expected the unhealthy server to be left out, got %v", got)
+	}
+	p.upstreams[1].unhealthy = true
+	p.upstreams[2].unhealthy = true
+	if got := addresses(p.order(client)); !equal(got, []string{"a:1", "b:1", "c:1"}) {
+		t.Errorf("expected all the servers when none is healthy, got %v", got)
+	}
+
+	if p := makeProxy("random"); p.Config().Validate() == nil {
+		t.Error("expected an error from an unknown balance strategy")
+	}
+}
+
+// TestHealthCheck checks that a server that fails its health checks is taken out of rotation and is put
+// back when it recovers.
+func TestHealthCheck(t *testing.T) {
+	server := startEchoServer(t)
+	defer server.Close()
+	address := server.Addr().String()
+
+	cl := clock.NewManualClock(time.Date(2020, time.February, 14, 15, 0, 0, 0, time.UTC))
+	config := Config{
+		Remotehost:  address,
+		Localhost:   "localhost",
+		HealthCheck: HealthCheck{Interval: Duration(time.Second), UnhealthyThreshold: 1},
+	}
+	p := New(config, WithClock(cl))
+	go p.Start(context.Background())
+	defer p.Stop()
+	waitForAddr(t, p)
+
+	// waitForHealth runs a health check and waits for the server to reach the given state.
+	waitForHealth := func(healthy bool) {
+		cl.BlockUntil(1)
+		cl.Advance(time.Second)
+		for i := 0; i < 500; i++ {
+			if p.Upstreams()[0].Healthy == healthy {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected the server's health to be %v", healthy)
+	}
+
+	server.Close()
+	waitForHealth(false)
+
+	server, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("cannot listen on %s - %v", address, err)
+	}
+	defer server.Close()
+	waitForHealth(true)
+}
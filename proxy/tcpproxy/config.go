@@ -0,0 +1,80 @@
+package tcpproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Config defines a proxy - the address it listens on, the servers for which
+// it acts as a proxy and whether it uses TLS.  It can be read from a JSON
+// file, for example:
+//
+//	{"Remotehosts": ["caster1.example.com:2101", "caster2.example.com:2101"],
+//	 "Localport": 2102, "Failover": {"Rounds": 5, "OpenTime": "1m"},
+//	 "Balance": "round-robin", "HealthCheck": {"Interval": "10s"}}
+type Config struct {
+	// Remotehost is the address of the server, host:port.  It's used if
+	// Remotehosts is empty.
+	Remotehost string
+	// Remotehosts is a list of servers, host:port.
+	Remotehosts []string
+	// Failover controls how the servers are tried.
+	Failover Failover
+	// Balance chooses how connections are spread across the servers -
+	// "failover" (the default), "round-robin", "least-connections" or
+	// "source-ip-hash".  Whichever server is chosen first, the others are
+	// tried in turn if it can't be reached.
+	Balance string
+	// HealthCheck controls the active health checks.
+	HealthCheck HealthCheck
+	// Localhost is the address to listen on.  "" means all addresses.
+	Localhost string
+	// Localport is the port to listen on.  0 means a port chosen by the system.
+	Localport int
+	// TLS is the subject of the generated certificate, used when there's no CertFile.
+	TLS *TLS
+	// CertFile names the certificate, loaded from CertFile.pem and CertFile.key.
+	CertFile string
+	// IsTLS makes the proxy accept TLS connections and use TLS to reach the server.
+	IsTLS bool
+	// UpstreamCA names a PEM file holding the certificates that the servers'
+	// certificates are checked against when IsTLS is set.  If it's "" the
+	// servers' certificates aren't checked.
+	UpstreamCA string
+}
+
+// Upstreams returns the addresses of the servers, in the order they are
+// tried.
+func (c Config) Upstreams() []string {
+	if len(c.Remotehosts) > 0 {
+		return c.Remotehosts
+	}
+	if c.Remotehost != "" {
+		return []string{c.Remotehost}
+	}
+	return nil
+}
+
+// Validate returns an error if the config is not usable.
+func (c Config) Validate() error {
+	if len(c.Upstreams()) == 0 {
+		return errors.New("no remote host")
+	}
+	return validBalance(c.Balance)
+}
+
+// ReadConfig reads a Config from a JSON file.
+func ReadConfig(configFile string) (Config, error) {
+	var config Config
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return config, fmt.Errorf("%s - %v", configFile, err)
+	}
+	return config, nil
+}
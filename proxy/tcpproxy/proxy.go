@@ -0,0 +1,574 @@
+// Package tcpproxy provides a Man In The Middle (MITM) TCP proxy.  It goes
+// between a client on the (probably) local machine and a server on a
+// (probably) remote machine, passing traffic both ways and recording it in a
+// report feed for the status reporter.
+//
+// A Proxy is created from a Config by New.  Start listens for clients and
+// serves them until its context is cancelled or Stop is called:
+//
+//	p := tcpproxy.New(tcpproxy.Config{Remotehost: "caster.example.com:2101", Localport: 2102},
+//	    tcpproxy.WithLogger(log))
+//	err := p.Start(ctx)
+//
+// Callbacks supplied with WithCallbacks are told when a connection opens and
+// closes and are given each buffer that passes through.
+package tcpproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+	"github.com/goblimey/go-tools/logger"
+	"github.com/goblimey/go-tools/proxy/reportfeed"
+)
+
+// dialTimeout limits the time spent trying to reach the server for a connection.
+const dialTimeout = 10 * time.Second
+
+// ErrAlreadyStarted is returned by Start and Serve if the proxy has already been started.
+var ErrAlreadyStarted = errors.New("the proxy has already been started")
+
+// ErrNoSuchConnection is returned by Drop if there is no open connection with the given ID.
+var ErrNoSuchConnection = errors.New("no such connection")
+
+// ConnectionInfo describes a connection through the proxy.
+type ConnectionInfo struct {
+	ID       uint64
+	Client   net.Addr // The address of the client.
+	Server   net.Addr // The address of the server.
+	Upstream string   // The server as given in the config, host:port.
+}
+
+// Callbacks are called by the proxy as connections open and close and as
+// traffic passes through.  Any of them may be nil.  The data callbacks are
+// called before the buffer is passed on and must not keep the buffer.
+type Callbacks struct {
+	OnConnect    func(info ConnectionInfo)
+	OnClientData func(info ConnectionInfo, data []byte)
+	OnServerData func(info ConnectionInfo, data []byte)
+	OnDisconnect func(info ConnectionInfo)
+	// OnUpstreamFailure is called instead of OnConnect if none of the servers
+	// can be reached.  The Server address in the info is nil.
+	OnUpstreamFailure func(info ConnectionInfo, err error)
+}
+
+// Option is an option for New.
+type Option func(*Proxy)
+
+// WithLogger makes the proxy write its log to the given logger.
+func WithLogger(log *logger.LoggerT) Option {
+	return func(p *Proxy) {
+		p.log = log
+	}
+}
+
+// WithReportFeed makes the proxy record its traffic in the given report feed.
+func WithReportFeed(feed *reportfeed.ReportFeed) Option {
+	return func(p *Proxy) {
+		p.feed = feed
+	}
+}
+
+// WithClock makes the proxy time its backoff and circuit breakers using the
+// given clock.  It's intended for use in tests.  The default is the system
+// clock.
+func WithClock(cl clock.TimerClock) Option {
+	return func(p *Proxy) {
+		p.clock = cl
+	}
+}
+
+// WithCallbacks sets the callbacks.
+func WithCallbacks(callbacks Callbacks) Option {
+	return func(p *Proxy) {
+		p.callbacks = callbacks
+	}
+}
+
+// Proxy passes traffic between clients and a server, chosen from the list in
+// its config.
+type Proxy struct {
+	config    Config
+	log       *logger.LoggerT
+	feed      *reportfeed.ReportFeed
+	callbacks Callbacks
+	clock     clock.TimerClock
+	upstreams []*upstream // The servers.  Their state is guarded by the mutex.
+
+	upstreamCAOnce sync.Once
+	upstreamCA     *x509.CertPool // The certificates loaded from config.UpstreamCA.
+	upstreamCAErr  error
+
+	ctx          context.Context // Cancelled by Stop, to abandon dials to the server.
+	cancel       func()
+	mutex        sync.Mutex
+	listener     net.Listener
+	started      bool
+	stopping     bool
+	stopped      chan struct{} // Closed by Stop.
+	nextID       uint64
+	nextUpstream uint64                // The next server for round-robin balancing.
+	connections  map[uint64]connection // The open connections, so that Drop and Stop can close them.
+	handlers     sync.WaitGroup        // The goroutines handling the connections.
+}
+
+// connection holds the two sides of a connection through the proxy.
+type connection struct {
+	info   ConnectionInfo
+	client net.Conn
+	server net.Conn
+}
+
+// New creates a Proxy.  By default it logs to a logger that's disabled and
+// records its traffic in a new report feed.  The options can change that.
+func New(config Config, options ...Option) *Proxy {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Proxy{
+		config:      config,
+		ctx:         ctx,
+		cancel:      cancel,
+		stopped:     make(chan struct{}),
+		connections: make(map[uint64]connection),
+	}
+	for _, option := range options {
+		option(&p)
+	}
+	if p.log == nil {
+		p.log = logger.New()
+	}
+	if p.feed == nil {
+		p.feed = reportfeed.New(p.log)
+	}
+	if p.clock == nil {
+		p.clock = &clock.SystemClock{}
+	}
+	for _, address := range config.Upstreams() {
+		p.upstreams = append(p.upstreams, &upstream{address: address})
+	}
+	p.feed.SetUpstreams(config.Upstreams())
+	return &p
+}
+
+// Config returns the proxy's config.
+func (p *Proxy) Config() Config {
+	return p.config
+}
+
+// ReportFeed returns the report feed in which the proxy records its traffic.
+func (p *Proxy) ReportFeed() *reportfeed.ReportFeed {
+	return p.feed
+}
+
+// Start listens on the configured address and serves clients until ctx is
+// cancelled or Stop is called.  It returns nil after a clean stop, otherwise
+// an error, for example if it can't listen.
+func (p *Proxy) Start(ctx context.Context) error {
+	listener, err := p.listen()
+	if err != nil {
+		return fmt.Errorf("failed to listen for clients - %v", err)
+	}
+	return p.Serve(ctx, listener)
+}
+
+// Serve serves clients that connect to the given listener until ctx is
+// cancelled or Stop is called.  The listener is closed when Serve returns.
+// Serve ignores the TLS settings in the config, so it's up to the caller to
+// supply a TLS listener if one is needed.  If the config asks for health
+// checks, they run while the proxy is serving.
+func (p *Proxy) Serve(ctx context.Context, listener net.Listener) error {
+	defer listener.Close()
+
+	err := validBalance(p.config.Balance)
+	if err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	if p.started {
+		p.mutex.Unlock()
+		return ErrAlreadyStarted
+	}
+	p.started = true
+	if p.stopping {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.listener = listener
+	if p.config.HealthCheck.Interval > 0 {
+		p.handlers.Add(1)
+		go p.checkHealth()
+	}
+	p.mutex.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Stop()
+		case <-p.stopped:
+		}
+	}()
+
+	p.log.Infof("[*] Listening for Client call ...")
+
+	for {
+		call, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-p.stopped:
+				p.handlers.Wait()
+				return nil
+			default:
+			}
+			p.log.Error("[-] failed to accept call from client", logger.F("error", err.Error()))
+			p.Stop()
+			return fmt.Errorf("failed to accept call from client - %v", err)
+		}
+		p.accept(call)
+	}
+}
+
+// Stop stops listening, closes the open connections and waits for their
+// handlers to finish.
+func (p *Proxy) Stop() {
+	p.mutex.Lock()
+	if !p.stopping {
+		p.stopping = true
+		close(p.stopped)
+		p.cancel()
+		if p.listener != nil {
+			p.listener.Close()
+		}
+		for _, c := range p.connections {
+			c.client.Close()
+			c.server.Close()
+		}
+	}
+	p.mutex.Unlock()
+	p.handlers.Wait()
+}
+
+// Addr returns the address that the proxy is listening on, or nil if it's not
+// listening.
+func (p *Proxy) Addr() net.Addr {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.listener == nil || p.stopping {
+		return nil
+	}
+	return p.listener.Addr()
+}
+
+// Drop closes both sides of a connection.
+func (p *Proxy) Drop(id uint64) error {
+	p.mutex.Lock()
+	c, ok := p.connections[id]
+	p.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("%w %d", ErrNoSuchConnection, id)
+	}
+	p.log.Info("[*] dropping connection", logger.F("connection", id))
+	c.client.Close()
+	c.server.Close()
+	return nil
+}
+
+// CheckUpstream checks that at least one of the servers can be reached,
+// giving up on each after the timeout.  It ignores the circuit breakers.
+func (p *Proxy) CheckUpstream(timeout time.Duration) error {
+	err := ErrNoUpstream
+	for _, u := range p.upstreams {
+		var conn net.Conn
+		conn, err = net.DialTimeout("tcp", u.address, timeout)
+		if err == nil {
+			return conn.Close()
+		}
+	}
+	return err
+}
+
+// UpstreamStatus describes the state of a server.
+type UpstreamStatus struct {
+	Address     string `json:"address"`
+	Breaker     string `json:"breaker"`     // The circuit breaker - "closed", "open" or "half-open".
+	Failures    int    `json:"failures"`    // Consecutive failures to connect.
+	Healthy     bool   `json:"healthy"`     // False if the server has failed its health checks.
+	Connections int    `json:"connections"` // Open connections using the server.
+}
+
+// Upstreams returns the state of the servers, in the order they are listed.
+func (p *Proxy) Upstreams() []UpstreamStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	status := make([]UpstreamStatus, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		status = append(status,
+			UpstreamStatus{u.address, u.breaker.state.String(), u.breaker.failures, !u.unhealthy, u.active})
+	}
+	return status
+}
+
+// localAddress returns the address to listen on.
+func (p *Proxy) localAddress() string {
+	return fmt.Sprint(p.config.Localhost, ":", p.config.Localport)
+}
+
+func (p *Proxy) listen() (net.Listener, error) {
+	if p.config.IsTLS {
+		return p.tlsListen()
+	}
+	p.log.Infof("listening on %s", p.localAddress())
+	return net.Listen("tcp", p.localAddress())
+}
+
+// accept starts handling a call from a client.  The server is dialled in the
+// connection's own goroutine, so a slow or unreachable server doesn't hold up
+// the listener.
+func (p *Proxy) accept(call net.Conn) {
+	p.mutex.Lock()
+	if p.stopping {
+		p.mutex.Unlock()
+		call.Close()
+		return
+	}
+	id := p.nextID
+	p.nextID++
+	p.handlers.Add(1)
+	p.mutex.Unlock()
+
+	p.log.Info("[*] connection accepted from client",
+		logger.F("connection", id), logger.F("peer", call.RemoteAddr().String()))
+
+	p.feed.RecordConnectionOpened(id, call.RemoteAddr().String())
+
+	go p.handleConnection(call, id)
+}
+
+// handleConnection connects a client to the server and passes traffic until
+// one side closes.  If the server can't be reached, the failure is logged and
+// counted and the client is closed.  The other connections carry on.
+func (p *Proxy) handleConnection(call net.Conn, id uint64) {
+	defer p.handlers.Done()
+
+	server, u, err := p.connectToServer(id, call.RemoteAddr())
+	if err != nil {
+		p.log.Error("[-] cannot connect to any server", logger.F("connection", id),
+			logger.F("error", err.Error()))
+		p.feed.RecordUpstreamFailure(id, err)
+		if p.callbacks.OnUpstreamFailure != nil {
+			p.callbacks.OnUpstreamFailure(ConnectionInfo{ID: id, Client: call.RemoteAddr()}, err)
+		}
+		call.Close()
+		p.feed.RecordConnectionClosed(id)
+		return
+	}
+	p.log.Info("[*] connected to server", logger.F("connection", id),
+		logger.F("upstream", u.address), logger.F("peer", server.RemoteAddr().String()))
+	p.feed.RecordUpstream(id, u.address)
+
+	c := connection{ConnectionInfo{id, call.RemoteAddr(), server.RemoteAddr(), u.address}, call, server}
+	p.mutex.Lock()
+	if p.stopping {
+		u.active--
+		p.mutex.Unlock()
+		call.Close()
+		server.Close()
+		p.feed.RecordConnectionClosed(id)
+		return
+	}
+	p.connections[id] = c
+	p.handlers.Add(1)
+	p.mutex.Unlock()
+
+	if p.callbacks.OnConnect != nil {
+		p.callbacks.OnConnect(c.info)
+	}
+
+	go p.handleServerMessages(c)
+	p.handleClientMessages(c)
+	c.server.Close()
+	c.client.Close()
+	p.mutex.Lock()
+	delete(p.connections, id)
+	u.active--
+	p.mutex.Unlock()
+	p.feed.RecordConnectionClosed(id)
+	if p.callbacks.OnDisconnect != nil {
+		p.callbacks.OnDisconnect(c.info)
+	}
+}
+
+// connectToServer tries the servers in the order given by the config's
+// Balance, skipping any whose circuit breaker is open, and returns a
+// connection to the first one that can be reached.  If none can be reached it
+// backs off and tries again, as set out in the config's Failover.  It gives up
+// when the proxy is stopped.  The server's count of open connections includes
+// the new connection.
+func (p *Proxy) connectToServer(id uint64, client net.Addr) (net.Conn, *upstream, error) {
+	failover := p.config.Failover.withDefaults()
+	backoff := time.Duration(failover.InitialBackoff)
+	err := ErrNoUpstream
+	for round := 1; ; round++ {
+		p.mutex.Lock()
+		upstreams := p.order(client)
+		p.mutex.Unlock()
+		for _, u := range upstreams {
+			p.mutex.Lock()
+			allowed := u.breaker.allow(p.clock.Now(), time.Duration(failover.OpenTime))
+			p.mutex.Unlock()
+			if !allowed {
+				continue
+			}
+
+			conn, dialErr := p.dial(u.address)
+			if p.ctx.Err() != nil {
+				// The proxy is stopping.
+				if conn != nil {
+					conn.Close()
+				}
+				return nil, nil, p.ctx.Err()
+			}
+			p.mutex.Lock()
+			if dialErr == nil {
+				u.breaker.success()
+				u.active++
+			} else {
+				u.breaker.failure(p.clock.Now(), failover.FailureThreshold)
+			}
+			p.mutex.Unlock()
+			if dialErr == nil {
+				return conn, u, nil
+			}
+
+			p.log.Info("[-] cannot connect to upstream", logger.F("connection", id),
+				logger.F("upstream", u.address), logger.F("error", dialErr.Error()))
+			p.feed.RecordDialFailure(u.address)
+			err = dialErr
+		}
+
+		if round >= failover.Rounds {
+			return nil, nil, err
+		}
+		timer := p.clock.NewTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-p.ctx.Done():
+			timer.Stop()
+			return nil, nil, p.ctx.Err()
+		}
+		backoff *= 2
+		if backoff > time.Duration(failover.MaxBackoff) {
+			backoff = time.Duration(failover.MaxBackoff)
+		}
+	}
+}
+
+// dial connects to a server.  If the proxy uses TLS, dial completes the
+// handshake, so a server that can't do TLS or whose certificate isn't trusted
+// fails like one that can't be reached.  The dial gives up after dialTimeout
+// or when the proxy is stopped.
+func (p *Proxy) dial(address string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(p.ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if !p.config.IsTLS {
+		return conn, nil
+	}
+
+	conf, err := p.upstreamTLSConfig(address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, conf)
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-p.ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	err = tlsConn.Handshake()
+	close(done)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake failed - %v", err)
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+func (p *Proxy) handleClientMessages(c connection) {
+	log := p.log
+	id := c.info.ID
+	for {
+		data := make([]byte, 2048)
+		n, err := c.client.Read(data)
+		if n > 0 {
+			log.Debug("buffer from client", logger.F("connection", id),
+				logger.F("peer", c.client.RemoteAddr().String()), logger.F("bytes", n))
+			if log.Enabled(logger.TraceLevel) {
+				log.Tracef("From Client [%d]:\n%s\n", id, hex.Dump(data[:n]))
+			}
+			// Hang onto the buffer for reporting until the next one arrives
+			p.feed.RecordClientBuffer(&data, id, n)
+			if p.callbacks.OnClientData != nil {
+				p.callbacks.OnClientData(c.info, data[:n])
+			}
+			c.server.Write(data[:n])
+		}
+		if err != nil {
+			if err == io.EOF {
+				log.Info("[*] client closed the connection", logger.F("connection", id))
+			}
+			return
+		}
+	}
+}
+
+func (p *Proxy) handleServerMessages(c connection) {
+	defer p.handlers.Done()
+
+	log := p.log
+	id := c.info.ID
+	for {
+		data := make([]byte, 2048)
+		n, err := c.server.Read(data)
+		if n > 0 {
+			log.Debug("buffer from server", logger.F("connection", id),
+				logger.F("peer", c.server.RemoteAddr().String()), logger.F("bytes", n))
+			if log.Enabled(logger.TraceLevel) {
+				log.Tracef("From Server [%d]:\n%s\n", id, hex.Dump(data[:n]))
+			}
+			// Hang onto the buffer for reporting until the next one arrives
+			p.feed.RecordServerBuffer(&data, id, n)
+			if p.callbacks.OnServerData != nil {
+				p.callbacks.OnServerData(c.info, data[:n])
+			}
+			c.client.Write(data[:n])
+		}
+		if err != nil {
+			// Close the client too, so that it knows the server has gone.
+			if err == io.EOF {
+				log.Info("[*] server closed the connection", logger.F("connection", id))
+			} else {
+				log.Error("[-] cannot read from server", logger.F("connection", id),
+					logger.F("error", err.Error()))
+			}
+			c.client.Close()
+			return
+		}
+	}
+}
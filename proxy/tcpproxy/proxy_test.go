@@ -0,0 +1,253 @@
+package tcpproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/proxy/reportfeed"
+)
+
+// TestProxy checks that the proxy passes traffic both ways, calls the callbacks, drops a connection on
+// request and stops when its context is cancelled.
+func TestProxy(t *testing.T) {
+	server := startEchoServer(t)
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var events []string
+	record := func(event string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	}
+	callbacks := Callbacks{
+		OnConnect:    func(info ConnectionInfo) { record("connect") },
+		OnClientData: func(info ConnectionInfo, data []byte) { record("client " + string(data)) },
+		OnServerData: func(info ConnectionInfo, data []byte) { record("server " + string(data)) },
+		OnDisconnect: func(info ConnectionInfo) { record("disconnect") },
+	}
+
+	p := New(Config{Remotehost: server.Addr().String(), Localhost: "localhost"}, WithCallbacks(callbacks))
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- p.Start(ctx) }()
+	addr := waitForAddr(t, p)
+
+	client, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("cannot connect to the proxy - %v", err)
+	}
+	defer client.Close()
+	_, err = client.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("cannot write to the proxy - %v", err)
+	}
+	reply := make([]byte, 5)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(client, reply)
+	if err != nil {
+		t.Fatalf("no reply from the proxy - %v", err)
+	}
+	if string(reply) != "hello" {
+		t.Errorf("expected \"hello\", got \"%s\"", reply)
+	}
+
+	if !errors.Is(p.Drop(42), ErrNoSuchConnection) {
+		t.Errorf("expected ErrNoSuchConnection when dropping an unknown connection")
+	}
+	err = p.Drop(0)
+	if err != nil {
+		t.Fatalf("Drop failed - %v", err)
+	}
+	_, err = client.Read(reply)
+	if err == nil {
+		t.Errorf("expected the dropped connection to be closed")
+	}
+
+	cancel()
+	select {
+	case err = <-result:
+		if err != nil {
+			t.Errorf("expected Start to return nil, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the proxy did not stop")
+	}
+	if p.Addr() != nil {
+		t.Errorf("expected no address after the proxy stopped")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := []string{"connect", "client hello", "server hello", "disconnect"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("expected events %v, got %v", expected, events)
+			break
+		}
+	}
+
+	// The report feed counted the traffic.
+	for _, metric := range p.ReportFeed().Metrics() {
+		if metric.Name == "proxy_connections_total" && metric.Value != 1 {
+			t.Errorf("expected 1 connection, got %v", metric.Value)
+		}
+	}
+}
+
+// TestStartReturnsListenError checks that Start returns an error if it can't listen.
+func TestStartReturnsListenError(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("cannot listen - %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	p := New(Config{Remotehost: "localhost:1", Localhost: "localhost", Localport: port})
+	if p.Start(context.Background()) == nil {
+		t.Error("expected an error when the port is in use")
+	}
+}
+
+// TestStop checks that Stop ends Start and that the proxy can't be started twice.
+func TestStop(t *testing.T) {
+	p := New(Config{Remotehost: "localhost:1", Localhost: "localhost"})
+	result := make(chan error, 1)
+	go func() { result <- p.Start(context.Background()) }()
+	waitForAddr(t, p)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("cannot listen - %v", err)
+	}
+	if p.Serve(context.Background(), listener) != ErrAlreadyStarted {
+		t.Error("expected ErrAlreadyStarted")
+	}
+
+	p.Stop()
+	select {
+	case err = <-result:
+		if err != nil {
+			t.Errorf("expected Start to return nil, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the proxy did not stop")
+	}
+}
+
+// TestUnreachableServer checks that a failure to reach the server closes the client, is counted and
+// leaves the proxy running.
+func TestUnreachableServer(t *testing.T) {
+	// Find a port that nothing is listening on.
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("cannot listen - %v", err)
+	}
+	remote := listener.Addr().String()
+	listener.Close()
+
+	failures := make(chan error, 2)
+	callbacks := Callbacks{OnUpstreamFailure: func(info ConnectionInfo, err error) { failures <- err }}
+	p := New(Config{Remotehost: remote, Localhost: "localhost"}, WithCallbacks(callbacks))
+	go p.Start(context.Background())
+	defer p.Stop()
+	addr := waitForAddr(t, p)
+
+	for i := 0; i < 2; i++ {
+		client, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("cannot connect to the proxy - %v", err)
+		}
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = client.Read(make([]byte, 1))
+		if err != io.EOF {
+			t.Errorf("expected the proxy to close the client, got %v", err)
+		}
+		client.Close()
+		select {
+		case <-failures:
+		case <-time.After(5 * time.Second):
+			t.Fatal("OnUpstreamFailure was not called")
+		}
+	}
+
+	if p.Addr() == nil {
+		t.Error("expected the proxy to keep listening")
+	}
+	report := p.ReportFeed().StructuredStatus().(reportfeed.StatusReport)
+	if report.UpstreamFailures != 2 {
+		t.Errorf("expected 2 upstream failures, got %d", report.UpstreamFailures)
+	}
+}
+
+// TestServerCloses checks that the client is closed when the server closes the connection.
+func TestServerCloses(t *testing.T) {
+	server, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("cannot listen - %v", err)
+	}
+	defer server.Close()
+	go func() {
+		conn, err := server.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	p := New(Config{Remotehost: server.Addr().String(), Localhost: "localhost"})
+	go p.Start(context.Background())
+	defer p.Stop()
+
+	client, err := net.Dial("tcp", waitForAddr(t, p))
+	if err != nil {
+		t.Fatalf("cannot connect to the proxy - %v", err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Errorf("expected the proxy to close the client, got %v", err)
+	}
+}
+
+// startEchoServer starts a server that sends back whatever it receives.
+func startEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("cannot listen - %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+// waitForAddr waits until the proxy is listening and returns its address.
+func waitForAddr(t *testing.T, p *Proxy) string {
+	for i := 0; i < 500; i++ {
+		if addr := p.Addr(); addr != nil {
+			return addr.String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the proxy did not start")
+	return ""
+}
@@ -0,0 +1,355 @@
+package tcpproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/goblimey/go-tools/dailylogger"
+	"github.com/goblimey/go-tools/logger"
+	"github.com/goblimey/go-tools/proxy/reportfeed"
+	"github.com/goblimey/go-tools/statusreporter"
+)
+
+// upstreamCheckTimeout limits the time that the health check spends trying
+// to reach the server.
+const upstreamCheckTimeout = 2 * time.Second
+
+// routesConfig is a config file that describes several routes.
+type routesConfig struct {
+	Routes []Route
+}
+
+// Route is a proxy described by a config file with several routes.  As well
+// as the proxy config it has a name, which labels it in the status reports,
+// and its own log settings.  A route with no Log shares the main log.
+type Route struct {
+	Config
+	Name     string
+	Log      string // The file to write the route's log to, "-" for stderr.
+	LogDaily bool   // If true, rotate the route's log daily.
+	LogJSON  bool   // If true, write the route's log as JSON lines.
+	LogLevel *uint8 // The level of the route's own log, by default the level of the main log, which it then follows.
+}
+
+// ReadRoutes reads the routes from a JSON config file, for example:
+//
+//	{"Routes": [
+//	  {"Name": "casters", "Remotehost": "caster.example.com:2101", "Localport": 2102,
+//	   "Log": "logs/casters.log", "LogDaily": true},
+//	  {"Name": "secure", "Remotehost": "caster.example.com:2102", "Localport": 2103,
+//	   "IsTLS": true, "LogLevel": 1}
+//	]}
+//
+// It returns nil if the file doesn't describe any routes, in which case it's
+// the config of a single proxy.  It returns an error if a route has no name,
+// if two routes have the same name or if a route's config is not usable.
+func ReadRoutes(configFile string) ([]Route, error) {
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	var config routesConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("%s - %v", configFile, err)
+	}
+
+	names := make(map[string]bool)
+	for i, r := range config.Routes {
+		if r.Name == "" {
+			return nil, fmt.Errorf("%s - route %d has no name", configFile, i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("%s - more than one route is called \"%s\"", configFile, r.Name)
+		}
+		names[r.Name] = true
+		err = r.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("%s - route %s - %v", configFile, r.Name, err)
+		}
+	}
+	return config.Routes, nil
+}
+
+// LogDestination returns the logger option for a log written to pathname.
+// The pathname "-" means stderr.  If daily is true the log is rotated daily:
+// with pathname "logs/proxy.log" the log for the 14th February 2020 would be
+// "logs/proxy.2020-02-14.log".  The logger doesn't close a daily log, so
+// LogDestination also returns it for the caller to close once the logger is
+// finished with.  Otherwise the io.Closer is nil.
+func LogDestination(pathname string, daily bool) (logger.Option, io.Closer, error) {
+	if pathname == "-" {
+		return logger.ToStderr(), nil, nil
+	}
+
+	if !daily {
+		return logger.ToFile(pathname), nil, nil
+	}
+
+	// Split "logs/proxy.log" into "logs", "proxy." and ".log".
+	dir := filepath.Dir(pathname)
+	trailer := filepath.Ext(pathname)
+	leader := strings.TrimSuffix(filepath.Base(pathname), trailer) + "."
+	dw, err := dailylogger.New(dir, leader, trailer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return logger.ToDailyLog(dw), dw, nil
+}
+
+// Routes is a set of proxies, one for each route, that share a status
+// reporter.  A single proxy set up without a config file of routes is a Routes
+// with one route that has no name.
+type Routes struct {
+	log    *logger.LoggerT
+	routes []runningRoute
+	feed   statusreporter.ReportFeedT
+}
+
+// runningRoute is a route whose proxy has been created.
+type runningRoute struct {
+	name     string
+	proxy    *Proxy
+	log      *logger.LoggerT
+	logFile  string
+	dailyLog io.Closer // The route's daily log, if it has one.
+	ownLevel bool      // True if the route's log level is set by its config.
+}
+
+// NewRoutes creates the proxies for the routes.  A route with its own Log gets
+// its own logger, otherwise it uses log, the main log, which is at logLevel and
+// is written to logFile.  Each proxy reports itself unhealthy if it isn't
+// listening and unready if it can't reach any of its servers.  Each log file
+// adds a readiness check that its directory is writable.
+func NewRoutes(routes []Route, log *logger.LoggerT, logLevel uint8, logFile string) (*Routes, error) {
+	rs := Routes{log: log}
+	for _, r := range routes {
+		rr, err := newRunningRoute(r, log, logLevel, logFile)
+		if err != nil {
+			rs.Close()
+			if r.Name != "" {
+				return nil, fmt.Errorf("route %s - %v", r.Name, err)
+			}
+			return nil, err
+		}
+		rs.routes = append(rs.routes, rr)
+	}
+
+	// A single proxy has the status reporter to itself.  Several share it,
+	// each with its own section of the report.
+	if len(rs.routes) == 1 && rs.routes[0].name == "" {
+		rf := rs.routes[0].proxy.ReportFeed()
+		addLogCheck(&rf.HealthRegistry, logFile)
+		rs.feed = rf
+	} else {
+		var feeds []reportfeed.Route
+		for _, rr := range rs.routes {
+			if rr.log != log {
+				addLogCheck(&rr.proxy.ReportFeed().HealthRegistry, rr.logFile)
+			}
+			feeds = append(feeds,
+				reportfeed.Route{Name: rr.name, Feed: rr.proxy.ReportFeed(), FixedLevel: rr.ownLevel})
+		}
+		routesFeed := reportfeed.NewRoutesFeed(feeds...)
+		addLogCheck(&routesFeed.HealthRegistry, logFile)
+		rs.feed = routesFeed
+	}
+	return &rs, nil
+}
+
+// newRunningRoute creates the proxy for a route and adds its health checks.
+func newRunningRoute(r Route, log *logger.LoggerT, logLevel uint8, logFile string) (runningRoute, error) {
+	routeLog := log
+	var dailyLog io.Closer
+	if r.Log != "" {
+		logDestination, dw, err := LogDestination(r.Log, r.LogDaily)
+		if err != nil {
+			return runningRoute{}, err
+		}
+		dailyLog = dw
+		logOptions := []logger.Option{logDestination}
+		if r.LogJSON {
+			logOptions = append(logOptions, logger.WithJSON())
+		}
+		routeLog = logger.New(logOptions...)
+		logFile = r.Log
+		if r.LogLevel != nil {
+			logLevel = *r.LogLevel
+		}
+		err = routeLog.SetLogLevel(logLevel)
+		if err != nil {
+			if dailyLog != nil {
+				dailyLog.Close()
+			}
+			return runningRoute{}, err
+		}
+	}
+
+	rf := reportfeed.New(routeLog)
+	p := New(r.Config, WithLogger(routeLog), WithReportFeed(rf))
+	rf.AddHealthCheck("listener up", false, func() error {
+		if p.Addr() == nil {
+			return errors.New("not listening for clients")
+		}
+		return nil
+	})
+	rf.AddHealthCheck("upstream reachable", true, func() error {
+		return p.CheckUpstream(upstreamCheckTimeout)
+	})
+	ownLevel := r.Log != "" && r.LogLevel != nil
+	return runningRoute{r.Name, p, routeLog, logFile, dailyLog, ownLevel}, nil
+}
+
+// addLogCheck adds a health check that the directory of the log file is
+// writable.  If it's not, the proxy is unready.  A log written to stderr has no
+// check.
+func addLogCheck(registry *statusreporter.HealthRegistry, logFile string) {
+	if logFile == "-" {
+		return
+	}
+	logDir := filepath.Dir(logFile)
+	registry.AddHealthCheck("log directory writable", true, func() error {
+		f, err := ioutil.TempFile(logDir, ".healthcheck")
+		if err != nil {
+			return err
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	})
+}
+
+// ReportFeed returns the report feed for the status reporter.  A single
+// proxy's feed is its own.  The feed of several is a reportfeed.RoutesFeed.
+func (rs *Routes) ReportFeed() statusreporter.ReportFeedT {
+	return rs.feed
+}
+
+// Proxy returns the proxy for the named route.  The name can be omitted if
+// there's only one route.
+func (rs *Routes) Proxy(name string) (*Proxy, error) {
+	if name == "" {
+		if len(rs.routes) == 1 {
+			return rs.routes[0].proxy, nil
+		}
+		return nil, fmt.Errorf("the route must be given - %w", statusreporter.ErrInvalidArgument)
+	}
+	for _, rr := range rs.routes {
+		if rr.name == name {
+			return rr.proxy, nil
+		}
+	}
+	return nil, fmt.Errorf("no route %s - %w", name, statusreporter.ErrInvalidArgument)
+}
+
+// Commands returns the control commands for the status reporter: drop, which
+// closes a connection, and reopenlog, which closes and reopens the log files.
+func (rs *Routes) Commands() []statusreporter.Command {
+	return []statusreporter.Command{
+		{
+			Name: "drop",
+			Help: "Close both sides of a connection.",
+			Params: []statusreporter.Param{
+				{Name: "connection", Type: statusreporter.IntParam, Required: true},
+				{Name: "route", Type: statusreporter.StringParam,
+					Help: "The route that the connection belongs to, if there are several."},
+			},
+			Handler: func(args statusreporter.Args) (string, error) {
+				p, err := rs.Proxy(args.String("route"))
+				if err != nil {
+					return "", err
+				}
+				id := args.Int("connection")
+				if id < 0 {
+					return "", fmt.Errorf("no open connection %d - %w", id, statusreporter.ErrInvalidArgument)
+				}
+				err = p.Drop(uint64(id))
+				if errors.Is(err, ErrNoSuchConnection) {
+					return "", fmt.Errorf("no open connection %d - %w", id, statusreporter.ErrInvalidArgument)
+				}
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("dropped connection %d", id), nil
+			},
+		},
+		{
+			Name: "reopenlog",
+			Help: "Close and reopen the log file, for example after it has been rotated by an external program.",
+			Handler: func(args statusreporter.Args) (string, error) {
+				err := rs.log.SetLogLevel(rs.log.Level())
+				if err != nil {
+					return "", err
+				}
+				for _, rr := range rs.routes {
+					if rr.log != rs.log {
+						err = rr.log.SetLogLevel(rr.log.Level())
+						if err != nil {
+							return "", err
+						}
+					}
+				}
+				return "log reopened", nil
+			},
+		},
+	}
+}
+
+// Start starts the proxies and serves clients until ctx is cancelled.  If one
+// of the proxies fails, for example because it can't listen, Start stops the
+// others and returns the error.  Otherwise it returns nil once they have all
+// stopped.
+func (rs *Routes) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan error, len(rs.routes))
+	for _, rr := range rs.routes {
+		go func(rr runningRoute) {
+			err := rr.proxy.Start(ctx)
+			if err != nil && rr.name != "" {
+				err = fmt.Errorf("route %s - %v", rr.name, err)
+			}
+			results <- err
+		}(rr)
+	}
+
+	var firstErr error
+	for range rs.routes {
+		err := <-results
+		if err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	return firstErr
+}
+
+// Close closes the logs that belong to the routes.  The main log is left to
+// the caller.  Close should be called once Start has returned.
+func (rs *Routes) Close() error {
+	var firstErr error
+	for _, rr := range rs.routes {
+		if rr.log == rs.log {
+			continue
+		}
+		err := rr.log.Close()
+		if rr.dailyLog != nil {
+			dailyErr := rr.dailyLog.Close()
+			if err == nil {
+				err = dailyErr
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("route %s - %v", rr.name, err)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,222 @@
+package tcpproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/logger"
+	"github.com/goblimey/go-tools/proxy/reportfeed"
+	ts "github.com/goblimey/go-tools/testsupport"
+)
+
+// TestReadRoutes checks that ReadRoutes reads the routes and rejects unusable ones.
+func TestReadRoutes(t *testing.T) {
+
+	// This test uses the filestore.
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	var testData = []struct {
+		description   string
+		contents      string
+		expectedNames []string
+		expectedError string
+	}{
+		{"two routes",
+			`{"Routes": [{"Name": "casters", "Remotehost": "a:1"}, {"Name": "web", "Remotehosts": ["b:1"]}]}`,
+			[]string{"casters", "web"}, ""},
+		{"single proxy", `{"Remotehost": "a:1"}`, nil, ""},
+		{"no name", `{"Routes": [{"Remotehost": "a:1"}]}`, nil, "route 1 has no name"},
+		{"same name", `{"Routes": [{"Name": "web", "Remotehost": "a:1"}, {"Name": "web", "Remotehost": "b:1"}]}`,
+			nil, "more than one route is called \"web\""},
+		{"no server", `{"Routes": [{"Name": "web"}]}`, nil, "route web - no remote host"},
+		{"not JSON", `{"Routes": [`, nil, "routes.json - "},
+	}
+
+	for _, td := range testData {
+		err := ioutil.WriteFile("routes.json", []byte(td.contents), 0644)
+		if err != nil {
+			t.Fatalf("cannot write the config file - %v", err)
+		}
+		routes, err := ReadRoutes("routes.json")
+		if td.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), td.expectedError) {
+				t.Errorf("%s: expected an error containing \"%s\", got %v", td.description, td.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ReadRoutes failed - %v", td.description, err)
+			continue
+		}
+		if len(routes) != len(td.expectedNames) {
+			t.Errorf("%s: expected %d routes, got %d", td.description, len(td.expectedNames), len(routes))
+			continue
+		}
+		for i, name := range td.expectedNames {
+			if routes[i].Name != name {
+				t.Errorf("%s: expected route %d to be %s, got %s", td.description, i, name, routes[i].Name)
+			}
+		}
+	}
+}
+
+// TestNewRoutes checks the report feed, the health checks and the lookup of the proxies.
+func TestNewRoutes(t *testing.T) {
+
+	// This test uses the filestore.
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	log := logger.New(logger.ToWriter(ioutil.Discard))
+
+	// A single proxy has the report feed to itself.
+	single, err := NewRoutes([]Route{{Config: Config{Remotehost: "a:1"}}}, log, 0, "proxy.log")
+	if err != nil {
+		t.Fatalf("NewRoutes failed - %v", err)
+	}
+	rf, ok := single.ReportFeed().(*reportfeed.ReportFeed)
+	if !ok {
+		t.Fatalf("expected a single proxy to have its own report feed, got %T", single.ReportFeed())
+	}
+	var names []string
+	for _, check := range rf.HealthChecks() {
+		names = append(names, check.Name)
+	}
+	expected := "listener up, upstream reachable, log directory writable"
+	if strings.Join(names, ", ") != expected {
+		t.Errorf("expected the checks %s, got %v", expected, names)
+	}
+	if _, err := single.Proxy(""); err != nil {
+		t.Errorf("expected the only proxy without a name - %v", err)
+	}
+
+	// Several share a RoutesFeed.  A route with its own log checks its own directory.  A route with its own
+	// log level keeps it when the level is set for the routes.
+	level := uint8(logger.InfoLevel)
+	routes := []Route{
+		{Config: Config{Remotehost: "a:1"}, Name: "casters", Log: "casters.log", LogLevel: &level},
+		{Config: Config{Remotehost: "b:1"}, Name: "web"},
+	}
+	several, err := NewRoutes(routes, log, 0, "proxy.log")
+	if err != nil {
+		t.Fatalf("NewRoutes failed - %v", err)
+	}
+	defer several.Close()
+	routesFeed, ok := several.ReportFeed().(*reportfeed.RoutesFeed)
+	if !ok {
+		t.Fatalf("expected a RoutesFeed, got %T", several.ReportFeed())
+	}
+	names = nil
+	for _, check := range routesFeed.HealthChecks() {
+		names = append(names, check.Name)
+	}
+	expected = "log directory writable, casters: listener up, casters: upstream reachable, " +
+		"casters: log directory writable, web: listener up, web: upstream reachable"
+	if strings.Join(names, ", ") != expected {
+		t.Errorf("expected the checks %s, got %v", expected, names)
+	}
+
+	routesFeed.SetLogLevel(logger.DebugLevel)
+	casters, _ := several.Proxy("casters")
+	if casters.ReportFeed().GetLogLevel() != logger.InfoLevel || log.Level() != logger.DebugLevel {
+		t.Errorf("expected casters to keep level %d and the main log to be at level %d, got %d and %d",
+			logger.InfoLevel, logger.DebugLevel, casters.ReportFeed().GetLogLevel(), log.Level())
+	}
+
+	if _, err := several.Proxy(""); err == nil {
+		t.Error("expected an error when the route isn't given")
+	}
+	if _, err := several.Proxy("nonexistent"); err == nil {
+		t.Error("expected an error from an unknown route")
+	}
+	p, err := several.Proxy("web")
+	if err != nil || p.Config().Remotehost != "b:1" {
+		t.Errorf("expected the proxy for web, got %v - %v", p, err)
+	}
+}
+
+// TestRoutesClose checks that Close closes the routes' own logs and leaves the main log alone.
+func TestRoutesClose(t *testing.T) {
+
+	// This test uses the filestore.
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	log := logger.New(logger.ToWriter(ioutil.Discard))
+	log.SetLogLevel(logger.InfoLevel)
+	level := uint8(logger.InfoLevel)
+	routes := []Route{
+		{Config: Config{Remotehost: "a:1"}, Name: "casters", Log: "casters.log", LogDaily: true, LogLevel: &level},
+		{Config: Config{Remotehost: "b:1"}, Name: "web", Log: "web.log", LogLevel: &level},
+		{Config: Config{Remotehost: "c:1"}, Name: "shared"},
+	}
+	rs, err := NewRoutes(routes, log, logger.InfoLevel, "-")
+	if err != nil {
+		t.Fatalf("NewRoutes failed - %v", err)
+	}
+
+	err = rs.Close()
+	if err != nil {
+		t.Fatalf("Close failed - %v", err)
+	}
+	if _, err := rs.routes[0].dailyLog.(io.Writer).Write([]byte("x")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected the daily log to be closed, got %v", err)
+	}
+	if rs.routes[1].log.Level() != 0 {
+		t.Error("expected the route's log to be closed")
+	}
+	if log.Level() != logger.InfoLevel {
+		t.Error("expected the main log to be left open")
+	}
+}
+
+// TestRoutesStart checks that if one proxy can't listen, Start stops the others and returns the error.
+func TestRoutesStart(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("cannot listen - %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	log := logger.New(logger.ToWriter(ioutil.Discard))
+	routes := []Route{
+		{Config: Config{Remotehost: "localhost:1", Localhost: "localhost"}, Name: "casters"},
+		{Config: Config{Remotehost: "localhost:1", Localhost: "localhost", Localport: port}, Name: "web"},
+	}
+	rs, err := NewRoutes(routes, log, 0, "-")
+	if err != nil {
+		t.Fatalf("NewRoutes failed - %v", err)
+	}
+
+	result := make(chan error, 1)
+	go func() { result <- rs.Start(context.Background()) }()
+	select {
+	case err = <-result:
+		if err == nil || !strings.HasPrefix(err.Error(), "route web - ") {
+			t.Errorf("expected an error from route web, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return")
+	}
+}
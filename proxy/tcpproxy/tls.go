@@ -0,0 +1,125 @@
+package tcpproxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+)
+
+// TLS holds the subject of the self-signed certificate that the proxy generates when it's given no
+// certificate file, for example Country ["GB"] and CommonName "*.domain.com".
+type TLS struct {
+	Country    []string
+	Org        []string
+	CommonName string
+}
+
+// genCert generates a self-signed certificate for the given subject and
+// returns it with its private key.
+func genCert(subject *TLS) ([]byte, *rsa.PrivateKey, error) {
+	ca := &x509.Certificate{
+		SerialNumber: big.NewInt(1653),
+		Subject: pkix.Name{
+			Country:      subject.Country,
+			Organization: subject.Org,
+			CommonName:   subject.CommonName,
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		SubjectKeyId:          []byte{1, 2, 3, 4, 5},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot generate key - %v", err)
+	}
+	pub := &priv.PublicKey
+	caB, err := x509.CreateCertificate(rand.Reader, ca, ca, pub, priv)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create certificate - %v", err)
+	}
+	return caB, priv, nil
+}
+
+// LoadCertificate loads the certificate from certFile.pem and the key from
+// certFile.key.  If certFile is "" it generates a self-signed certificate for
+// the given subject, which may be nil.
+func LoadCertificate(certFile string, subject *TLS) (tls.Certificate, error) {
+	if certFile != "" {
+		return tls.LoadX509KeyPair(fmt.Sprint(certFile, ".pem"), fmt.Sprint(certFile, ".key"))
+	}
+
+	if subject == nil {
+		subject = &TLS{}
+	}
+	caB, priv, err := genCert(subject)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert := tls.Certificate{
+		Certificate: [][]byte{caB},
+		PrivateKey:  priv,
+	}
+	return cert, nil
+}
+
+// tlsListen listens for TLS connections on the proxy's local address.
+func (p *Proxy) tlsListen() (conn net.Listener, err error) {
+	if p.config.CertFile == "" {
+		p.log.Info("[*] generating a self-signed certificate")
+	}
+	cert, err := LoadCertificate(p.config.CertFile, p.config.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	conf.Rand = rand.Reader
+
+	conn, err = tls.Listen("tcp", p.localAddress(), &conf)
+	return
+}
+
+// upstreamTLSConfig returns the TLS config for a connection to the server at
+// the given address.  If the config names an UpstreamCA, the server's
+// certificate must be signed by one of the certificates in it, which are
+// loaded the first time they're needed.
+func (p *Proxy) upstreamTLSConfig(address string) (*tls.Config, error) {
+	if p.config.UpstreamCA == "" {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+
+	p.upstreamCAOnce.Do(func() {
+		pem, err := ioutil.ReadFile(p.config.UpstreamCA)
+		if err != nil {
+			p.upstreamCAErr = err
+			return
+		}
+		p.upstreamCA = x509.NewCertPool()
+		if !p.upstreamCA.AppendCertsFromPEM(pem) {
+			p.upstreamCAErr = fmt.Errorf("%s holds no certificates", p.config.UpstreamCA)
+		}
+	})
+	if p.upstreamCAErr != nil {
+		return nil, p.upstreamCAErr
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{RootCAs: p.upstreamCA, ServerName: host}, nil
+}
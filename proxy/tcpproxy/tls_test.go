@@ -0,0 +1,170 @@
+package tcpproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	ts "github.com/goblimey/go-tools/testsupport"
+)
+
+// TestLoadCertificate checks that a self-signed certificate is generated for the subject when there's no
+// certificate file, and that a missing file is an error.
+func TestLoadCertificate(t *testing.T) {
+	cert, err := LoadCertificate("", &TLS{Country: []string{"GB"}, CommonName: "proxy.example.com"})
+	if err != nil {
+		t.Fatalf("cannot generate a certificate - %v", err)
+	}
+	if len(cert.Certificate) != 1 || cert.PrivateKey == nil {
+		t.Fatalf("expected a certificate and a key, got %+v", cert)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("cannot parse the certificate - %v", err)
+	}
+	if parsed.Subject.CommonName != "proxy.example.com" {
+		t.Errorf("expected common name proxy.example.com, got %s", parsed.Subject.CommonName)
+	}
+
+	_, err = LoadCertificate("/nonexistent/cert", nil)
+	if err == nil {
+		t.Error("expected an error from a missing certificate file")
+	}
+}
+
+// TestUntrustedUpstream checks that a server whose certificate isn't signed by the UpstreamCA counts as a
+// failed dial, so the proxy fails over to the next server.
+func TestUntrustedUpstream(t *testing.T) {
+
+	// This test uses the filestore.
+
+	directoryName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(directoryName)
+
+	untrustedCert, err := LoadCertificate("", nil)
+	if err != nil {
+		t.Fatalf("cannot generate a certificate - %v", err)
+	}
+	untrusted := startTLSEchoServer(t, untrustedCert)
+	defer untrusted.Close()
+
+	trustedCert := localhostCert(t)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: trustedCert.Certificate[0]})
+	err = ioutil.WriteFile("ca.pem", caPEM, 0644)
+	if err != nil {
+		t.Fatalf("cannot write the CA file - %v", err)
+	}
+	trusted := startTLSEchoServer(t, trustedCert)
+	defer trusted.Close()
+
+	config := Config{
+		Remotehosts: []string{localhostAddress(untrusted), localhostAddress(trusted)},
+		IsTLS:       true,
+		UpstreamCA:  "ca.pem",
+	}
+	p := New(config)
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("cannot listen - %v", err)
+	}
+	go p.Serve(context.Background(), listener)
+	defer p.Stop()
+
+	client, err := net.Dial("tcp", waitForAddr(t, p))
+	if err != nil {
+		t.Fatalf("cannot connect to the proxy - %v", err)
+	}
+	defer client.Close()
+	_, err = client.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("cannot write to the proxy - %v", err)
+	}
+	reply := make([]byte, 5)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(client, reply)
+	if err != nil || string(reply) != "hello" {
+		t.Fatalf("expected \"hello\" from the trusted server, got \"%s\" - %v", reply, err)
+	}
+
+	upstreams := p.Upstreams()
+	if upstreams[0].Failures != 1 || upstreams[1].Failures != 0 {
+		t.Errorf("expected one failure for the untrusted server, got %+v", upstreams)
+	}
+	found := false
+	for _, metric := range p.ReportFeed().Metrics() {
+		if metric.Name == "proxy_upstream_dial_failures_total" {
+			if metric.Labels["upstream"] != config.Remotehosts[0] || metric.Value != 1 {
+				t.Errorf("expected one dial failure for %s, got %+v", config.Remotehosts[0], metric)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Error("expected the dial failure to be counted")
+	}
+}
+
+// startTLSEchoServer starts a TLS server that presents the given certificate and sends back whatever it
+// receives.
+func startTLSEchoServer(t *testing.T, cert tls.Certificate) net.Listener {
+	listener, err := tls.Listen("tcp", "localhost:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("cannot listen - %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+// localhostCert returns a self-signed certificate for the host name localhost.
+func localhostCert(t *testing.T) tls.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("cannot generate key - %v", err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatalf("cannot create certificate - %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+}
+
+// localhostAddress returns the listener's address with the host name localhost, to match localhostCert.
+func localhostAddress(listener net.Listener) string {
+	return net.JoinHostPort("localhost", fmt.Sprint(listener.Addr().(*net.TCPAddr).Port))
+}
@@ -0,0 +1,160 @@
+package tcpproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The defaults for the Failover settings.
+const (
+	DefaultRounds           = 3
+	DefaultInitialBackoff   = 100 * time.Millisecond
+	DefaultMaxBackoff       = 5 * time.Second
+	DefaultFailureThreshold = 3
+	DefaultOpenTime         = 30 * time.Second
+)
+
+// ErrNoUpstream is returned when none of the upstream servers can be tried,
+// for example because all of their circuit breakers are open.
+var ErrNoUpstream = errors.New("no upstream server available")
+
+// Duration is a time.Duration that's written in JSON as a string such as
+// "10s".  A number is taken as nanoseconds.
+type Duration time.Duration
+
+// MarshalJSON satisfies the json.Marshaler interface.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON satisfies the json.Unmarshaler interface.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		duration, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(duration)
+		return nil
+	}
+	var n int64
+	err := json.Unmarshal(data, &n)
+	if err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	*d = Duration(n)
+	return nil
+}
+
+// Failover controls how the proxy tries its upstream servers.  For each
+// client it tries the servers in order, skipping any whose circuit breaker
+// is open.  If none of them can be reached it waits and tries the list again,
+// doubling the wait each time, for the given number of rounds.  An upstream's
+// circuit breaker opens after FailureThreshold consecutive failures.  It stays
+// open for OpenTime, then one trial connection is allowed through.  If that
+// succeeds the breaker closes, otherwise it opens again.  Zero values mean the
+// defaults.
+type Failover struct {
+	Rounds           int
+	InitialBackoff   Duration
+	MaxBackoff       Duration
+	FailureThreshold int
+	OpenTime         Duration
+}
+
+// withDefaults returns the settings with the defaults filled in.
+func (f Failover) withDefaults() Failover {
+	if f.Rounds <= 0 {
+		f.Rounds = DefaultRounds
+	}
+	if f.InitialBackoff <= 0 {
+		f.InitialBackoff = Duration(DefaultInitialBackoff)
+	}
+	if f.MaxBackoff <= 0 {
+		f.MaxBackoff = Duration(DefaultMaxBackoff)
+	}
+	if f.FailureThreshold <= 0 {
+		f.FailureThreshold = DefaultFailureThreshold
+	}
+	if f.OpenTime <= 0 {
+		f.OpenTime = Duration(DefaultOpenTime)
+	}
+	return f
+}
+
+// breakerState is the state of a circuit breaker.
+type breakerState int
+
+const (
+	// breakerClosed lets connections through.
+	breakerClosed breakerState = iota
+	// breakerOpen stops connections until the open time has passed.
+	breakerOpen
+	// breakerHalfOpen has let one trial connection through and is waiting for the result.
+	breakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops the proxy trying an upstream that keeps failing.
+type circuitBreaker struct {
+	state    breakerState
+	failures int       // Consecutive failures.
+	openedAt time.Time // When the breaker last opened.
+}
+
+// allow returns true if a connection may be tried.  When the breaker has been
+// open for openTime it lets one trial connection through.
+func (b *circuitBreaker) allow(now time.Time, openTime time.Duration) bool {
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < openTime {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+// success records a successful connection, closing the breaker.
+func (b *circuitBreaker) success() {
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// failure records a failed connection.  The breaker opens after threshold
+// consecutive failures, or if the trial connection fails.
+func (b *circuitBreaker) failure(now time.Time, threshold int) {
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= threshold {
+		b.state = breakerOpen
+		b.openedAt = now
+	}
+}
+
+// upstream is a server that the proxy can connect to.
+type upstream struct {
+	address       string
+	breaker       circuitBreaker
+	active        int  // The number of open connections using the server.
+	unhealthy     bool // True if the server has failed its health checks.
+	checkFailures int  // Consecutive failed health checks.
+	checkPasses   int  // Consecutive passed health checks.
+}
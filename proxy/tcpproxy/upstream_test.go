@@ -0,0 +1,141 @@
+package tcpproxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+	"github.com/goblimey/go-tools/proxy/reportfeed"
+)
+
+// TestCircuitBreaker checks that the breaker opens after the threshold, lets one trial through after the
+// open time and closes again if the trial succeeds.
+func TestCircuitBreaker(t *testing.T) {
+	start := time.Date(2020, time.February, 14, 15, 0, 0, 0, time.UTC)
+	const openTime = time.Minute
+	var b circuitBreaker
+
+	for i := 0; i < 2; i++ {
+		if !b.allow(start, openTime) {
+			t.Fatalf("attempt %d: expected the breaker to allow a connection", i)
+		}
+		b.failure(start, 2)
+	}
+	if b.state != breakerOpen || b.allow(start.Add(59*time.Second), openTime) {
+		t.Fatalf("expected the breaker to be open, got %v", b.state)
+	}
+
+	// After the open time one trial is allowed.  If it fails the breaker opens again.
+	later := start.Add(openTime)
+	if !b.allow(later, openTime) || b.allow(later, openTime) {
+		t.Fatal("expected the breaker to allow exactly one trial")
+	}
+	b.failure(later, 2)
+	if b.state != breakerOpen || b.allow(later.Add(59*time.Second), openTime) {
+		t.Fatalf("expected the breaker to open again, got %v", b.state)
+	}
+
+	// If the trial succeeds the breaker closes.
+	later = later.Add(openTime)
+	if !b.allow(later, openTime) {
+		t.Fatal("expected the breaker to allow a trial")
+	}
+	b.success()
+	if b.state != breakerClosed || b.failures != 0 || !b.allow(later, openTime) {
+		t.Errorf("expected the breaker to be closed, got %v with %d failures", b.state, b.failures)
+	}
+}
+
+// TestDurationJSON checks that a Duration can be read from a string or a number and written as a string.
+func TestDurationJSON(t *testing.T) {
+	var failover Failover
+	err := json.Unmarshal([]byte(`{"InitialBackoff": "250ms", "OpenTime": 1000000000}`), &failover)
+	if err != nil {
+		t.Fatalf("cannot read the failover settings - %v", err)
+	}
+	if time.Duration(failover.InitialBackoff) != 250*time.Millisecond || time.Duration(failover.OpenTime) != time.Second {
+		t.Errorf("expected 250ms and 1s, got %v and %v",
+			time.Duration(failover.InitialBackoff), time.Duration(failover.OpenTime))
+	}
+	body, err := json.Marshal(failover.OpenTime)
+	if err != nil || string(body) != `"1s"` {
+		t.Errorf("expected \"1s\", got %s (%v)", body, err)
+	}
+	if json.Unmarshal([]byte(`{"OpenTime": "junk"}`), &failover) == nil {
+		t.Error("expected an error from an invalid duration")
+	}
+}
+
+// TestFailover checks that the proxy skips an unreachable server, backs off when none can be reached and
+// records the server in use by each connection.
+func TestFailover(t *testing.T) {
+	dead := unusedAddress(t)
+	later := unusedAddress(t)
+	cl := clock.NewManualClock(time.Date(2020, time.February, 14, 15, 0, 0, 0, time.UTC))
+	config := Config{
+		Remotehosts: []string{dead, later},
+		Localhost:   "localhost",
+		Failover:    Failover{Rounds: 2, InitialBackoff: Duration(time.Second), FailureThreshold: 2},
+	}
+	connected := make(chan ConnectionInfo, 1)
+	p := New(config, WithClock(cl), WithCallbacks(Callbacks{
+		OnConnect: func(info ConnectionInfo) { connected <- info },
+	}))
+	go p.Start(context.Background())
+	defer p.Stop()
+
+	client, err := net.Dial("tcp", waitForAddr(t, p))
+	if err != nil {
+		t.Fatalf("cannot connect to the proxy - %v", err)
+	}
+	defer client.Close()
+
+	// Neither server can be reached, so the proxy backs off.  Start the second server and let the proxy
+	// try again.  The first server fails again, which opens its circuit breaker.
+	cl.BlockUntil(1)
+	server, err := net.Listen("tcp", later)
+	if err != nil {
+		t.Fatalf("cannot listen on %s - %v", later, err)
+	}
+	defer server.Close()
+	go func() {
+		conn, err := server.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}
+	}()
+	cl.Advance(time.Second)
+
+	select {
+	case info := <-connected:
+		if info.Upstream != later {
+			t.Errorf("expected upstream %s, got %s", later, info.Upstream)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the proxy did not connect to the second server")
+	}
+
+	report := p.ReportFeed().StructuredStatus().(reportfeed.StatusReport)
+	if len(report.Connections) != 1 || report.Connections[0].Upstream != later {
+		t.Errorf("expected one connection using %s, got %+v", later, report.Connections)
+	}
+	upstreams := p.Upstreams()
+	if len(upstreams) != 2 || upstreams[0].Breaker != "open" || upstreams[1].Breaker != "closed" {
+		t.Errorf("expected the first breaker open and the second closed, got %+v", upstreams)
+	}
+}
+
+// unusedAddress returns the address of a local port that nothing is listening on.
+func unusedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("cannot listen - %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
@@ -0,0 +1,379 @@
+package statusreporter
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// RoleRead is the default role required by the read-only requests - the status reports.
+const RoleRead = "read"
+
+// RoleControl is the default role required by the control requests - setting the log level.
+const RoleControl = "control"
+
+// Authenticator identifies the sender of an HTTP request.  Authenticate returns the roles granted to the sender
+// and true, or false if the request doesn't carry credentials that the Authenticator accepts.
+type Authenticator interface {
+	Authenticate(request *http.Request) (roles []string, ok bool)
+}
+
+// SetAuthenticators sets the Authenticators that the Reporter consults, in order, to identify the sender of a
+// request.  The first one that accepts the request decides the sender's roles.  With no Authenticators, which
+// is the default, all requests are allowed.
+func (r *Reporter) SetAuthenticators(authenticators ...Authenticator) {
+	r.AuthenticatorsPriv = authenticators
+}
+
+// SetReadRole sets the role required by the read-only requests.  The default is RoleRead.  "" means that the
+// read-only requests are open to anybody.
+func (r *Reporter) SetReadRole(role string) {
+	r.ReadRolePriv = role
+}
+
+// SetControlRole sets the role required by the control requests.  The default is RoleControl.  "" means that
+// the control requests are open to anybody.
+func (r *Reporter) SetControlRole(role string) {
+	r.ControlRolePriv = role
+}
+
+// requireRole wraps a handler so that it's only run if the sender of the request has the given role.  The
+// role is fetched when the request arrives, so it can be changed after the handlers are registered.
+func (r *Reporter) requireRole(role func() string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if r.authorise(writer, request, role()) {
+			handler(writer, request)
+		}
+	}
+}
+
+// readRole returns the role required by the read-only requests.
+func (r *Reporter) readRole() string {
+	return r.ReadRolePriv
+}
+
+// controlRole returns the role required by the control requests.
+func (r *Reporter) controlRole() string {
+	return r.ControlRolePriv
+}
+
+// authorise returns true if the request may go ahead.  Otherwise it sends 401 Unauthorized if the sender
+// can't be identified, or 403 Forbidden if the sender doesn't have the role, and returns false.
+func (r *Reporter) authorise(writer http.ResponseWriter, request *http.Request, role string) bool {
+	if len(r.AuthenticatorsPriv) == 0 || role == "" {
+		return true
+	}
+
+	for _, authenticator := range r.AuthenticatorsPriv {
+		roles, ok := authenticator.Authenticate(request)
+		if !ok {
+			continue
+		}
+		for _, granted := range roles {
+			if granted == role {
+				return true
+			}
+		}
+		http.Error(writer, "forbidden", http.StatusForbidden)
+		return false
+	}
+
+	writer.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", r.ServiceNamePriv))
+	writer.Header().Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", r.ServiceNamePriv))
+	http.Error(writer, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+// BasicAuth authenticates requests using HTTP basic authentication against a list of users and password
+// hashes, usually read from an htpasswd file.
+type BasicAuth struct {
+	users map[string]basicAuthUser
+}
+
+// basicAuthUser holds the password hash and roles of a BasicAuth user.
+type basicAuthUser struct {
+	hash  string
+	roles []string
+}
+
+// LoadHtpasswd reads an htpasswd file and returns a BasicAuth that checks requests against it.  Each line
+// contains a user name and a password hash separated by a colon, optionally followed by another colon and
+// a comma-separated list of roles, for example:
+//
+//	alice:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/:read,control
+//	bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=
+//
+// A user without a list of roles is given the default roles.  The hashes may be Apache MD5 ("$apr1$", the
+// default for htpasswd) or SHA-1 ("{SHA}", htpasswd -s).  Bcrypt isn't supported because the Go standard
+// library doesn't provide it.  Blank lines and lines starting with '#' are ignored.
+func LoadHtpasswd(pathname string, defaultRoles ...string) (*BasicAuth, error) {
+	file, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	auth := &BasicAuth{users: make(map[string]basicAuthUser)}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, fmt.Errorf("%s line %d: expected user:hash", pathname, lineNumber)
+		}
+		roles := defaultRoles
+		if len(fields) == 3 {
+			roles = splitList(fields[2])
+		}
+		err = auth.AddUser(fields[0], fields[1], roles...)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", pathname, lineNumber, err.Error())
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// AddUser adds a user with the given password hash and roles.  It returns an error if the hash is not in a
+// supported format.
+func (ba *BasicAuth) AddUser(user, hash string, roles ...string) error {
+	if !strings.HasPrefix(hash, "$apr1$") && !strings.HasPrefix(hash, "{SHA}") {
+		return fmt.Errorf("user %s: unsupported password hash - use htpasswd -m or -s", user)
+	}
+	if ba.users == nil {
+		ba.users = make(map[string]basicAuthUser)
+	}
+	ba.users[user] = basicAuthUser{hash, roles}
+	return nil
+}
+
+// Authenticate satisfies the Authenticator interface.
+func (ba *BasicAuth) Authenticate(request *http.Request) ([]string, bool) {
+	name, password, ok := request.BasicAuth()
+	if !ok {
+		return nil, false
+	}
+	user, ok := ba.users[name]
+	if !ok {
+		return nil, false
+	}
+
+	var hash string
+	switch {
+	case strings.HasPrefix(user.hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		hash = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		// "$apr1$salt$hash".
+		parts := strings.SplitN(user.hash, "$", 4)
+		if len(parts) != 4 {
+			return nil, false
+		}
+		hash = apr1(password, parts[2])
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(user.hash)) != 1 {
+		return nil, false
+	}
+	return user.roles, true
+}
+
+// BearerTokens authenticates requests that carry an "Authorization: Bearer" header with one of a fixed set
+// of tokens.
+type BearerTokens struct {
+	tokens map[string][]string
+}
+
+// NewBearerTokens returns a BearerTokens that accepts the given tokens.  The map gives the roles granted to
+// each token.
+func NewBearerTokens(tokens map[string][]string) *BearerTokens {
+	bt := &BearerTokens{tokens: make(map[string][]string)}
+	for token, roles := range tokens {
+		bt.tokens[token] = roles
+	}
+	return bt
+}
+
+// LoadBearerTokens reads a file of tokens and returns a BearerTokens that accepts them.  Each line contains a
+// token, optionally followed by white space and a comma-separated list of roles, for example:
+//
+//	6f1ed002ab5595859014ebf0951522d9 read,control
+//
+// A token without a list of roles is given the default roles.  Blank lines and lines starting with '#' are
+// ignored.
+func LoadBearerTokens(pathname string, defaultRoles ...string) (*BearerTokens, error) {
+	file, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	tokens := make(map[string][]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		roles := defaultRoles
+		if len(fields) > 1 {
+			roles = splitList(fields[1])
+		}
+		tokens[fields[0]] = roles
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return NewBearerTokens(tokens), nil
+}
+
+// Authenticate satisfies the Authenticator interface.
+func (bt *BearerTokens) Authenticate(request *http.Request) ([]string, bool) {
+	header := request.Header.Get("Authorization")
+	const scheme = "bearer "
+	if len(header) <= len(scheme) || strings.ToLower(header[:len(scheme)]) != scheme {
+		return nil, false
+	}
+	offered := []byte(strings.TrimSpace(header[len(scheme):]))
+	// Compare against every token so that the time taken doesn't reveal which one nearly matched.
+	var roles []string
+	found := false
+	for token, tokenRoles := range bt.tokens {
+		if subtle.ConstantTimeCompare(offered, []byte(token)) == 1 {
+			roles = tokenRoles
+			found = true
+		}
+	}
+	return roles, found
+}
+
+// ClientCertificates authenticates requests that arrive over TLS with a verified client certificate.  The
+// server's tls.Config must ask for client certificates and verify them, for example with ClientAuth set to
+// tls.VerifyClientCertIfGiven and ClientCAs set to the CA that issued them.
+type ClientCertificates struct {
+	// Roles gives the roles granted to each certificate, keyed by the certificate's subject common name.
+	Roles map[string][]string
+	// DefaultRoles are granted to a verified certificate whose common name is not in Roles.  If it's nil,
+	// such certificates are not accepted.
+	DefaultRoles []string
+}
+
+// Authenticate satisfies the Authenticator interface.
+func (cc *ClientCertificates) Authenticate(request *http.Request) ([]string, bool) {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+		return nil, false
+	}
+	commonName := request.TLS.VerifiedChains[0][0].Subject.CommonName
+	if roles, ok := cc.Roles[commonName]; ok {
+		return roles, true
+	}
+	if cc.DefaultRoles != nil {
+		return cc.DefaultRoles, true
+	}
+	return nil, false
+}
+
+// Check that the supplied authenticators satisfy the interface.
+var _ Authenticator = (*BasicAuth)(nil)
+var _ Authenticator = (*BearerTokens)(nil)
+var _ Authenticator = (*ClientCertificates)(nil)
+
+// splitList splits a comma-separated list, ignoring empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// apr1 returns the Apache MD5 hash of a password with the given salt, in the form "$apr1$salt$hash", as
+// produced by htpasswd -m.
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+	s := []byte(salt)
+
+	alternate := md5.New()
+	alternate.Write(pw)
+	alternate.Write(s)
+	alternate.Write(pw)
+	final := alternate.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic))
+	ctx.Write(s)
+	for i := len(pw); i > 0; i -= 16 {
+		n := i
+		if n > 16 {
+			n = 16
+		}
+		ctx.Write(final[:n])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final = ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 == 1 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write(s)
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 == 1 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var result []byte
+	encode := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			result = append(result, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(final[0], final[6], final[12], 4)
+	encode(final[1], final[7], final[13], 4)
+	encode(final[2], final[8], final[14], 4)
+	encode(final[3], final[9], final[15], 4)
+	encode(final[4], final[10], final[5], 4)
+	encode(0, 0, final[11], 2)
+
+	return magic + salt + "$" + string(result)
+}
@@ -0,0 +1,164 @@
+package statusreporter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ts "github.com/goblimey/go-tools/testsupport"
+)
+
+// TestApr1 checks the Apache MD5 hash against values produced by "openssl passwd -apr1".
+func TestApr1(t *testing.T) {
+	var testData = []struct {
+		password string
+		salt     string
+		expected string
+	}{
+		{"myPassword", "r31.....", "$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/"},
+		{"s3cret", "abcdefgh", "$apr1$abcdefgh$M2T3erDstkD7SsE2QQnfH0"},
+	}
+
+	for _, td := range testData {
+		got := apr1(td.password, td.salt)
+		if got != td.expected {
+			t.Errorf("%s: expected %s, got %s", td.password, td.expected, got)
+		}
+	}
+}
+
+// TestLoadHtpasswd checks that an htpasswd file is read and used to authenticate requests.
+func TestLoadHtpasswd(t *testing.T) {
+	testDirName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(testDirName)
+
+	const contents = `# users
+alice:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/:read,control
+
+bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=
+`
+	err = ioutil.WriteFile("htpasswd", []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("cannot write htpasswd file - %v", err)
+	}
+
+	auth, err := LoadHtpasswd("htpasswd", RoleRead)
+	if err != nil {
+		t.Fatalf("LoadHtpasswd failed - %v", err)
+	}
+
+	var testData = []struct {
+		user          string
+		password      string
+		expectedOK    bool
+		expectedRoles int
+	}{
+		{"alice", "myPassword", true, 2},
+		{"alice", "password", false, 0},
+		{"bob", "password", true, 1},
+		{"carol", "password", false, 0},
+	}
+
+	for _, td := range testData {
+		request := httptest.NewRequest("GET", "/status/report", nil)
+		request.SetBasicAuth(td.user, td.password)
+		roles, ok := auth.Authenticate(request)
+		if ok != td.expectedOK || len(roles) != td.expectedRoles {
+			t.Errorf("%s/%s: expected %v with %d roles, got %v with %v",
+				td.user, td.password, td.expectedOK, td.expectedRoles, ok, roles)
+		}
+	}
+
+	// Bcrypt hashes are not supported.
+	err = ioutil.WriteFile("htpasswd", []byte("dave:$2y$05$abcdefghijklmnopqrstuv\n"), 0644)
+	if err != nil {
+		t.Fatalf("cannot write htpasswd file - %v", err)
+	}
+	_, err = LoadHtpasswd("htpasswd")
+	if err == nil {
+		t.Error("expected an error for a bcrypt hash")
+	}
+}
+
+// TestAuthorisation checks that the read-only and control requests require their roles.
+func TestAuthorisation(t *testing.T) {
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	reporter.SetAuthenticators(NewBearerTokens(map[string][]string{
+		"reader":   {RoleRead},
+		"operator": {RoleRead, RoleControl},
+	}))
+
+	var testData = []struct {
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{"GET", "/status/report", "", http.StatusUnauthorized},
+		{"GET", "/status/report", "junk", http.StatusUnauthorized},
+		{"GET", "/status/report", "reader", http.StatusOK},
+		{"GET", "/status/stylesheet.css", "", http.StatusOK},
+		{"POST", "/status/loglevel/3", "reader", http.StatusForbidden},
+		{"POST", "/status/loglevel/3", "operator", http.StatusOK},
+		{"GET", "/status/loglevel", "reader", http.StatusOK},
+		{"GET", "/status/loglevel/", "reader", http.StatusOK},
+		{"GET", "/status/loglevel/", "", http.StatusUnauthorized},
+		{"GET", "/status/loglevel/3", "reader", http.StatusMethodNotAllowed},
+	}
+
+	for _, td := range testData {
+		request := httptest.NewRequest(td.method, td.path, nil)
+		if td.token != "" {
+			request.Header.Set("Authorization", "Bearer "+td.token)
+		}
+		recorder := httptest.NewRecorder()
+		reporter.ServeHTTP(recorder, request)
+		if recorder.Code != td.expectedStatus {
+			t.Errorf("%s %s with token \"%s\": expected status %d, got %d",
+				td.method, td.path, td.token, td.expectedStatus, recorder.Code)
+		}
+		if recorder.Code == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s %s: expected a WWW-Authenticate header", td.method, td.path)
+		}
+	}
+
+	// Making the control requests open lets anybody set the log level.
+	reporter.SetControlRole("")
+	request := httptest.NewRequest("POST", "/status/loglevel/0", nil)
+	recorder := httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+}
+
+// TestClientCertificates checks authentication by the client certificate.
+func TestClientCertificates(t *testing.T) {
+	auth := &ClientCertificates{Roles: map[string][]string{"ops": {RoleControl}}}
+
+	request := httptest.NewRequest("GET", "/status/report", nil)
+	_, ok := auth.Authenticate(request)
+	if ok {
+		t.Error("expected a request without TLS to be rejected")
+	}
+
+	for _, commonName := range []string{"ops", "someone"} {
+		certificate := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+		request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}}
+		roles, ok := auth.Authenticate(request)
+		expectedOK := commonName == "ops"
+		if ok != expectedOK {
+			t.Errorf("%s: expected %v, got %v", commonName, expectedOK, ok)
+		}
+		if ok && (len(roles) != 1 || roles[0] != RoleControl) {
+			t.Errorf("%s: expected the control role, got %v", commonName, roles)
+		}
+	}
+}
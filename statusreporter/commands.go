@@ -0,0 +1,256 @@
+package statusreporter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CommandRequestMiddle defines the middle part of the HTTP request for a command, eg "/status/command/drop".
+const CommandRequestMiddle = "/command/"
+
+// ErrInvalidArgument can be wrapped in the error returned by a command handler to say that the caller made
+// a mistake, for example by naming a connection that doesn't exist.  The request then fails with 400 Bad
+// Request rather than 500 Internal Server Error.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// commandNameRE defines the legal names of commands and parameters.
+var commandNameRE = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+// ParamType is the type of a command parameter.
+type ParamType int
+
+const (
+	// StringParam is any string.
+	StringParam ParamType = iota
+	// IntParam is a decimal integer.
+	IntParam
+	// BoolParam is true or false, as accepted by strconv.ParseBool.
+	BoolParam
+	// DurationParam is a duration, as accepted by time.ParseDuration, for example "10m".
+	DurationParam
+)
+
+// String returns the name of the type.
+func (pt ParamType) String() string {
+	switch pt {
+	case IntParam:
+		return "int"
+	case BoolParam:
+		return "bool"
+	case DurationParam:
+		return "duration"
+	default:
+		return "string"
+	}
+}
+
+// Param describes a parameter of a command.
+type Param struct {
+	Name     string
+	Type     ParamType
+	Required bool
+	Help     string
+}
+
+// Args holds the parameters of a command, converted to the types given by the command's Params - string,
+// int, bool or time.Duration.  Optional parameters that weren't supplied are missing.
+type Args map[string]interface{}
+
+// String returns a string parameter, or "" if it's missing.
+func (a Args) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+// Int returns an int parameter, or 0 if it's missing.
+func (a Args) Int(name string) int {
+	i, _ := a[name].(int)
+	return i
+}
+
+// Bool returns a bool parameter, or false if it's missing.
+func (a Args) Bool(name string) bool {
+	b, _ := a[name].(bool)
+	return b
+}
+
+// Duration returns a duration parameter, or 0 if it's missing.
+func (a Args) Duration(name string) time.Duration {
+	d, _ := a[name].(time.Duration)
+	return d
+}
+
+// Command is a control command that the embedding program registers with the Reporter, for example "drop"
+// with an int parameter "connection".  The Reporter runs it in response to
+// POST /{service}/command/{name}, with the parameters in the query or in a form-encoded body.  The handler
+// returns a message for the caller, or an error.
+type Command struct {
+	Name    string
+	Help    string
+	Params  []Param
+	Handler func(args Args) (string, error)
+}
+
+// RegisterCommand adds a command to the Reporter.  It returns an error if the command is invalid or one with
+// the same name has already been registered.  It can be called while the Reporter is serving requests.
+func (r *Reporter) RegisterCommand(command Command) error {
+	if !commandNameRE.MatchString(command.Name) {
+		return fmt.Errorf("invalid command name \"%s\"", command.Name)
+	}
+	if command.Handler == nil {
+		return fmt.Errorf("command %s has no handler", command.Name)
+	}
+	seen := make(map[string]bool)
+	for _, param := range command.Params {
+		if !commandNameRE.MatchString(param.Name) || seen[param.Name] {
+			return fmt.Errorf("command %s: invalid or duplicate parameter name \"%s\"", command.Name, param.Name)
+		}
+		seen[param.Name] = true
+	}
+
+	// The handlers read the commands concurrently.
+	state := r.state()
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if _, ok := r.findCommand(command.Name); ok {
+		return fmt.Errorf("command %s is already registered", command.Name)
+	}
+	r.CommandsPriv = append(r.CommandsPriv, command)
+	return nil
+}
+
+// Commands returns a copy of the registered commands, in the order they were registered.
+func (r *Reporter) Commands() []Command {
+	state := r.state()
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return append([]Command(nil), r.CommandsPriv...)
+}
+
+// command returns the named command.  The second result is false if there isn't one.
+func (r *Reporter) command(name string) (Command, bool) {
+	state := r.state()
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return r.findCommand(name)
+}
+
+// findCommand is command for callers that already hold the state mutex.
+func (r *Reporter) findCommand(name string) (Command, bool) {
+	for _, command := range r.CommandsPriv {
+		if command.Name == name {
+			return command, true
+		}
+	}
+	return Command{}, false
+}
+
+// HandleCommandRequest responds to a POST /{servicename}/command/{name} HTTP request by validating the
+// parameters and running the command.  Other methods get 405 Method Not Allowed, an unknown command gets
+// 404 Not Found and invalid parameters get 400 Bad Request.
+func (r *Reporter) HandleCommandRequest(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		writer.Header().Set("Allow", http.MethodPost)
+		http.Error(writer, "commands must be sent using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(request.URL.Path, r.CommandRequestPriv)
+	command, ok := r.command(name)
+	if !ok {
+		http.Error(writer, fmt.Sprintf("no such command \"%s\"", name), http.StatusNotFound)
+		return
+	}
+
+	err := request.ParseForm()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	args, err := command.parseArgs(request.Form)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := command.Handler(args)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrInvalidArgument) {
+			status = http.StatusBadRequest
+		}
+		fmt.Fprintf(os.Stderr, "command %s failed - %s\n", name, err.Error())
+		http.Error(writer, err.Error(), status)
+		return
+	}
+
+	r.publish(Event{Type: "command", Data: map[string]interface{}{"name": name, "args": args}})
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(writer, result)
+}
+
+// parseArgs checks the parameters of a request against the command's Params and converts them.
+func (c Command) parseArgs(values map[string][]string) (Args, error) {
+	args := make(Args)
+	known := make(map[string]bool)
+	for _, param := range c.Params {
+		known[param.Name] = true
+		vals, ok := values[param.Name]
+		if !ok || len(vals) == 0 {
+			if param.Required {
+				return nil, fmt.Errorf("command %s: missing parameter %s", c.Name, param.Name)
+			}
+			continue
+		}
+		if len(vals) > 1 {
+			return nil, fmt.Errorf("command %s: parameter %s given more than once", c.Name, param.Name)
+		}
+		value, err := param.parse(vals[0])
+		if err != nil {
+			return nil, fmt.Errorf("command %s: parameter %s must be a %s - %s",
+				c.Name, param.Name, param.Type, err.Error())
+		}
+		args[param.Name] = value
+	}
+	for name := range values {
+		if !known[name] {
+			return nil, fmt.Errorf("command %s: unknown parameter %s", c.Name, name)
+		}
+	}
+	return args, nil
+}
+
+// parse converts the string value of a parameter to its type.
+func (p *Param) parse(value string) (interface{}, error) {
+	switch p.Type {
+	case IntParam:
+		return strconv.Atoi(value)
+	case BoolParam:
+		return strconv.ParseBool(value)
+	case DurationParam:
+		return time.ParseDuration(value)
+	default:
+		return value, nil
+	}
+}
+
+// Usage returns a one-line description of the command, for example "drop connection=int".  Optional
+// parameters are shown in brackets.
+func (c Command) Usage() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	for _, param := range c.Params {
+		if param.Required {
+			fmt.Fprintf(&b, " %s=%s", param.Name, param.Type)
+		} else {
+			fmt.Fprintf(&b, " [%s=%s]", param.Name, param.Type)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,137 @@
+package statusreporter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCommandRequest checks that commands are routed, validated and run.
+func TestCommandRequest(t *testing.T) {
+	var dropped int
+	var wait time.Duration
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	err := reporter.RegisterCommand(Command{
+		Name: "drop",
+		Help: "Drop a connection.",
+		Params: []Param{
+			{Name: "connection", Type: IntParam, Required: true},
+			{Name: "wait", Type: DurationParam},
+		},
+		Handler: func(args Args) (string, error) {
+			if args.Int("connection") > 10 {
+				return "", fmt.Errorf("no connection %d - %w", args.Int("connection"), ErrInvalidArgument)
+			}
+			dropped = args.Int("connection")
+			wait = args.Duration("wait")
+			return "dropped", nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("RegisterCommand failed - %v", err)
+	}
+
+	var testData = []struct {
+		method         string
+		target         string
+		expectedStatus int
+	}{
+		{"GET", "/status/command/drop?connection=7", http.StatusMethodNotAllowed},
+		{"POST", "/status/command/junk", http.StatusNotFound},
+		{"POST", "/status/command/drop", http.StatusBadRequest},
+		{"POST", "/status/command/drop?connection=x", http.StatusBadRequest},
+		{"POST", "/status/command/drop?connection=7&colour=red", http.StatusBadRequest},
+		{"POST", "/status/command/drop?connection=11", http.StatusBadRequest},
+		{"POST", "/status/command/drop?connection=7&wait=10m", http.StatusOK},
+	}
+
+	for _, td := range testData {
+		request := httptest.NewRequest(td.method, td.target, nil)
+		recorder := httptest.NewRecorder()
+		reporter.ServeHTTP(recorder, request)
+		if recorder.Code != td.expectedStatus {
+			t.Errorf("%s %s: expected status %d, got %d - %s",
+				td.method, td.target, td.expectedStatus, recorder.Code, recorder.Body.String())
+		}
+	}
+	if dropped != 7 || wait != 10*time.Minute {
+		t.Errorf("expected connection 7 to be dropped after 10m, got %d after %v", dropped, wait)
+	}
+
+	// Parameters can also be sent in a form.
+	request := httptest.NewRequest("POST", "/status/command/drop", strings.NewReader("connection=3"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "dropped\n" || dropped != 3 {
+		t.Errorf("expected connection 3 to be dropped, got status %d, body %s, connection %d",
+			recorder.Code, recorder.Body.String(), dropped)
+	}
+
+	// Commands are listed on the report page.
+	request = httptest.NewRequest("GET", "/status/report", nil)
+	recorder = httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, request)
+	if !strings.Contains(recorder.Body.String(), "<code>drop connection=int [wait=duration]</code> Drop a connection.") {
+		t.Errorf("expected the report to list the drop command, got %s", recorder.Body.String())
+	}
+}
+
+// TestRegisterCommand checks that invalid commands are rejected.
+func TestRegisterCommand(t *testing.T) {
+	handler := func(args Args) (string, error) { return "", nil }
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+
+	err := reporter.RegisterCommand(Command{Name: "flush", Handler: handler})
+	if err != nil {
+		t.Fatalf("RegisterCommand failed - %v", err)
+	}
+
+	for _, command := range []Command{
+		{Name: "flush", Handler: handler},
+		{Name: "bad name", Handler: handler},
+		{Name: "nohandler"},
+		{Name: "twice", Handler: handler, Params: []Param{{Name: "a"}, {Name: "a"}}},
+	} {
+		err = reporter.RegisterCommand(command)
+		if err == nil {
+			t.Errorf("%s: expected an error", command.Name)
+		}
+	}
+	if len(reporter.Commands()) != 1 {
+		t.Errorf("expected 1 command, got %d", len(reporter.Commands()))
+	}
+}
+
+// TestRegisterCommandWhileServing checks that commands can be registered while requests are being handled.
+// It's only useful with the race detector.
+func TestRegisterCommandWhileServing(t *testing.T) {
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	handler := func(args Args) (string, error) { return "done", nil }
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			reporter.RegisterCommand(Command{Name: fmt.Sprintf("command%d", i), Handler: handler})
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		for _, target := range []string{"/status/command/command0", "/status/report"} {
+			method := http.MethodGet
+			if strings.Contains(target, "command") {
+				method = http.MethodPost
+			}
+			recorder := httptest.NewRecorder()
+			reporter.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+		}
+	}
+	<-done
+
+	if len(reporter.Commands()) != 20 {
+		t.Errorf("expected 20 commands, got %d", len(reporter.Commands()))
+	}
+}
@@ -0,0 +1,149 @@
+package statusreporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// HealthRequestEnd defines the end of the HTTP request for the liveness probe.
+const HealthRequestEnd = "/healthz"
+
+// ReadyRequestEnd defines the end of the HTTP request for the readiness probe.
+const ReadyRequestEnd = "/readyz"
+
+// Check is the result of a health check.
+type Check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+	// ReadinessOnly means that a failure makes the service unready but doesn't mean that it's unhealthy, for
+	// example when an upstream server is unreachable.
+	ReadinessOnly bool `json:"readinessOnly,omitempty"`
+}
+
+// HealthFeed is an optional interface that a ReportFeedT may also implement.  HealthChecks runs the feed's
+// health checks and returns the results.  The Reporter serves them at /{service}/healthz, which uses the
+// checks that are not ReadinessOnly, and /{service}/readyz, which uses all of them.  A feed can implement it
+// by embedding a HealthRegistry.
+type HealthFeed interface {
+	HealthChecks() []Check
+}
+
+// healthReport is the JSON form of the response to a health request.
+type healthReport struct {
+	Status string  `json:"status"` // "ok" or "failed".
+	Checks []Check `json:"checks"`
+}
+
+// HealthRegistry holds a set of named health checks.
+type HealthRegistry struct {
+	mutex  sync.Mutex
+	checks []registeredCheck
+}
+
+// registeredCheck is a health check held by a HealthRegistry.
+type registeredCheck struct {
+	name          string
+	readinessOnly bool
+	check         func() error
+}
+
+// Check that the HealthRegistry satisfies the HealthFeed interface.
+var _ HealthFeed = (*HealthRegistry)(nil)
+
+// AddHealthCheck adds a named check.  The check function returns nil if all is well, otherwise an error
+// explaining what's wrong.  If readinessOnly is true, a failure makes the service unready but not unhealthy.
+func (hr *HealthRegistry) AddHealthCheck(name string, readinessOnly bool, check func() error) {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+	hr.checks = append(hr.checks, registeredCheck{name, readinessOnly, check})
+}
+
+// HealthChecks satisfies the HealthFeed interface.  It runs the checks in the order they were added.
+func (hr *HealthRegistry) HealthChecks() []Check {
+	hr.mutex.Lock()
+	checks := make([]registeredCheck, len(hr.checks))
+	copy(checks, hr.checks)
+	hr.mutex.Unlock()
+
+	results := make([]Check, 0, len(checks))
+	for _, c := range checks {
+		result := Check{Name: c.name, OK: true, ReadinessOnly: c.readinessOnly}
+		err := c.check()
+		if err != nil {
+			result.OK = false
+			result.Message = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// HandleHealthRequest handles the liveness probe.  It responds with 200 OK if all of the feed's checks that
+// are not ReadinessOnly pass, otherwise 503 Service Unavailable.
+func (r *Reporter) HandleHealthRequest(writer http.ResponseWriter, request *http.Request) {
+	r.handleProbe(writer, request, false)
+}
+
+// HandleReadyRequest handles the readiness probe.  It responds with 200 OK if all of the feed's checks pass,
+// otherwise 503 Service Unavailable.
+func (r *Reporter) HandleReadyRequest(writer http.ResponseWriter, request *http.Request) {
+	r.handleProbe(writer, request, true)
+}
+
+// handleProbe runs the health checks and sends the result, as plain text or as JSON if the request's Accept
+// header prefers it.  If the feed doesn't implement HealthFeed, the service is taken to be healthy.
+func (r *Reporter) handleProbe(writer http.ResponseWriter, request *http.Request, readiness bool) {
+	report := healthReport{Status: "ok", Checks: []Check{}}
+	if feed, ok := r.ReportFeedPriv.(HealthFeed); ok {
+		for _, check := range feed.HealthChecks() {
+			if check.ReadinessOnly && !readiness {
+				continue
+			}
+			if !check.OK {
+				report.Status = "failed"
+			}
+			report.Checks = append(report.Checks, check)
+		}
+	}
+
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writer.Header().Set("Cache-Control", "no-cache")
+
+	if prefersJSON(request.Header.Get("Accept")) {
+		body, err := json.Marshal(report)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error encoding health report - %s\n", err.Error())
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(status)
+		writer.Write(body)
+		return
+	}
+
+	// For example:
+	//     [+] listener up ok
+	//     [-] upstream reachable failed: dial tcp 10.0.0.1:2101: connection refused
+	//     failed
+	var b strings.Builder
+	for _, check := range report.Checks {
+		if check.OK {
+			fmt.Fprintf(&b, "[+] %s ok\n", check.Name)
+		} else {
+			fmt.Fprintf(&b, "[-] %s failed: %s\n", check.Name, check.Message)
+		}
+	}
+	b.WriteString(report.Status + "\n")
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer.WriteHeader(status)
+	writer.Write([]byte(b.String()))
+}
@@ -0,0 +1,69 @@
+package statusreporter
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHealthRequests checks the liveness and readiness probes.
+func TestHealthRequests(t *testing.T) {
+	upstreamErr := errors.New("connection refused")
+	var listenerErr error
+	feed := &HealthFeedForTest{}
+	feed.AddHealthCheck("listener up", false, func() error { return listenerErr })
+	feed.AddHealthCheck("upstream reachable", true, func() error { return upstreamErr })
+
+	reporter := MakeReporter(feed, "localhost", 0)
+	// The probes don't need credentials.
+	reporter.SetAuthenticators(NewBearerTokens(nil))
+
+	var testData = []struct {
+		target         string
+		accept         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"/status/healthz", "", http.StatusOK, "[+] listener up ok\nok\n"},
+		{"/status/readyz", "", http.StatusServiceUnavailable,
+			"[+] listener up ok\n[-] upstream reachable failed: connection refused\nfailed\n"},
+		{"/status/readyz", "application/json", http.StatusServiceUnavailable,
+			`{"status":"failed","checks":[{"name":"listener up","ok":true},` +
+				`{"name":"upstream reachable","ok":false,"message":"connection refused","readinessOnly":true}]}`},
+	}
+
+	for _, td := range testData {
+		request := httptest.NewRequest("GET", td.target, nil)
+		if td.accept != "" {
+			request.Header.Set("Accept", td.accept)
+		}
+		recorder := httptest.NewRecorder()
+		reporter.ServeHTTP(recorder, request)
+		if recorder.Code != td.expectedStatus {
+			t.Errorf("%s: expected status %d, got %d", td.target, td.expectedStatus, recorder.Code)
+		}
+		if recorder.Body.String() != td.expectedBody {
+			t.Errorf("%s: expected body %q, got %q", td.target, td.expectedBody, recorder.Body.String())
+		}
+	}
+
+	// A failed liveness check fails both probes.
+	listenerErr = errors.New("not listening")
+	upstreamErr = nil
+	for _, target := range []string{"/status/healthz", "/status/readyz"} {
+		recorder := httptest.NewRecorder()
+		reporter.ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+		if recorder.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected status %d, got %d", target, http.StatusServiceUnavailable, recorder.Code)
+		}
+	}
+
+	// A feed without health checks is healthy.
+	reporter = MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	recorder := httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, httptest.NewRequest("GET", "/status/readyz", nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "ok\n" {
+		t.Errorf("expected status 200 and ok, got %d and %q", recorder.Code, recorder.Body.String())
+	}
+}
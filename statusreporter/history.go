@@ -0,0 +1,301 @@
+package statusreporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+)
+
+// HistoryRequestEnd defines the end of the HTTP request for the status history.
+const HistoryRequestEnd = "/history"
+
+// ErrHistoryRunning is returned by StartHistory if the sampler is already running.
+var ErrHistoryRunning = errors.New("status history is already running")
+
+// HistoryConfig controls the status history.
+type HistoryConfig struct {
+	// Interval is the time between snapshots.
+	Interval time.Duration
+	// Size is the number of snapshots kept.  When the history is full, the oldest snapshot is dropped.
+	Size int
+	// File is the name of a file to keep the history in, so that it survives a restart.  It's rewritten
+	// after each snapshot.  "" means that the history is only kept in memory.
+	File string
+}
+
+// Snapshot is the status at a point in time.  It holds the feed's structured status if the feed supplies
+// one, otherwise its status report, plus the feed's metrics if it supplies those.
+type Snapshot struct {
+	Time       time.Time          `json:"time"`
+	Report     string             `json:"report,omitempty"`
+	Structured json.RawMessage    `json:"structured,omitempty"`
+	Metrics    map[string]float64 `json:"metrics,omitempty"` // Keyed by name and labels, eg `proxy_bytes_total{direction="client_to_server"}`.
+}
+
+// historySampler takes snapshots at regular intervals and keeps the latest in a ring buffer.
+type historySampler struct {
+	mutex     sync.Mutex
+	config    HistoryConfig
+	snapshots []Snapshot // The ring buffer.
+	next      int        // The index of the slot for the next snapshot.
+	full      bool       // True once the ring buffer has wrapped.
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// StartHistory starts taking snapshots of the status in the background, using the Reporter's clock.  If
+// config.File names an existing history file, the history is loaded from it first.  It returns an error if
+// the config is invalid, if the history file can't be read or if the history is already running.
+func (r *Reporter) StartHistory(config HistoryConfig) error {
+	if config.Interval <= 0 || config.Size <= 0 {
+		return fmt.Errorf("status history needs a positive interval and size, got %v and %d",
+			config.Interval, config.Size)
+	}
+
+	sampler := &historySampler{
+		config:    config,
+		snapshots: make([]Snapshot, config.Size),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if config.File != "" {
+		err := sampler.load()
+		if err != nil {
+			return err
+		}
+	}
+
+	state := r.state()
+	state.mutex.Lock()
+	if state.history != nil && state.history.running() {
+		state.mutex.Unlock()
+		return ErrHistoryRunning
+	}
+	state.history = sampler
+	state.mutex.Unlock()
+
+	cl := r.timerClock()
+	ticker := cl.NewTicker(config.Interval)
+	go r.sampleHistory(sampler, cl.Now(), ticker)
+	return nil
+}
+
+// StopHistory stops taking snapshots.  The history is kept, so it can still be fetched, until the next
+// call of StartHistory.
+func (r *Reporter) StopHistory() {
+	state := r.state()
+	state.mutex.Lock()
+	sampler := state.history
+	state.mutex.Unlock()
+	if sampler == nil {
+		return
+	}
+
+	sampler.mutex.Lock()
+	select {
+	case <-sampler.stop:
+		// Already stopped.
+	default:
+		close(sampler.stop)
+	}
+	sampler.mutex.Unlock()
+	<-sampler.done
+}
+
+// running returns true if the sampler hasn't been stopped.
+func (hs *historySampler) running() bool {
+	select {
+	case <-hs.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// History returns the snapshots, oldest first, or nil if the history hasn't been started.
+func (r *Reporter) History() []Snapshot {
+	state := r.state()
+	state.mutex.Lock()
+	sampler := state.history
+	state.mutex.Unlock()
+	if sampler == nil {
+		return nil
+	}
+
+	sampler.mutex.Lock()
+	defer sampler.mutex.Unlock()
+	return sampler.list()
+}
+
+// sampleHistory takes a snapshot straight away and then on each tick until the history is stopped.  It
+// should be run in a goroutine.
+func (r *Reporter) sampleHistory(sampler *historySampler, start time.Time, ticker clock.Ticker) {
+	defer close(sampler.done)
+	defer ticker.Stop()
+
+	sampler.add(r.takeSnapshot(start))
+	for {
+		select {
+		case <-sampler.stop:
+			return
+		case now := <-ticker.C():
+			sampler.add(r.takeSnapshot(now))
+		}
+	}
+}
+
+// takeSnapshot returns a snapshot of the feed's status.
+func (r *Reporter) takeSnapshot(now time.Time) Snapshot {
+	snapshot := Snapshot{Time: now}
+	if feed, ok := r.ReportFeedPriv.(StructuredReportFeed); ok {
+		structured, err := json.Marshal(feed.StructuredStatus())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error encoding status for history - %s\n", err.Error())
+		}
+		snapshot.Structured = structured
+	} else {
+		snapshot.Report = string(r.ReportFeedPriv.Status())
+	}
+	if feed, ok := r.ReportFeedPriv.(MetricsFeed); ok {
+		snapshot.Metrics = make(map[string]float64)
+		for _, metric := range feed.Metrics() {
+			var b strings.Builder
+			b.WriteString(metric.Name)
+			writeLabels(&b, metric.Labels)
+			snapshot.Metrics[b.String()] = metric.Value
+		}
+	}
+	return snapshot
+}
+
+// add adds a snapshot to the history, dropping the oldest if it's full, and saves the history if it's
+// kept in a file.
+func (hs *historySampler) add(snapshot Snapshot) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	hs.snapshots[hs.next] = snapshot
+	hs.next++
+	if hs.next == len(hs.snapshots) {
+		hs.next = 0
+		hs.full = true
+	}
+
+	if hs.config.File != "" {
+		err := hs.save()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot save status history in %s - %s\n", hs.config.File, err.Error())
+		}
+	}
+}
+
+// list returns the snapshots, oldest first.
+func (hs *historySampler) list() []Snapshot {
+	if !hs.full {
+		return append([]Snapshot(nil), hs.snapshots[:hs.next]...)
+	}
+	list := append([]Snapshot(nil), hs.snapshots[hs.next:]...)
+	return append(list, hs.snapshots[:hs.next]...)
+}
+
+// save writes the history to its file.  It writes a temporary file and renames it, so a crash doesn't
+// leave a partial history behind.
+func (hs *historySampler) save() error {
+	body, err := json.Marshal(hs.list())
+	if err != nil {
+		return err
+	}
+	temporary, err := ioutil.TempFile(filepath.Dir(hs.config.File), filepath.Base(hs.config.File)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = temporary.Write(body)
+	closeErr := temporary.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temporary.Name(), hs.config.File)
+	}
+	if err != nil {
+		os.Remove(temporary.Name())
+	}
+	return err
+}
+
+// load reads the history from its file, keeping the newest snapshots if there are more than the history
+// holds.  A missing file is not an error.
+func (hs *historySampler) load() error {
+	body, err := ioutil.ReadFile(hs.config.File)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snapshots []Snapshot
+	err = json.Unmarshal(body, &snapshots)
+	if err != nil {
+		return fmt.Errorf("cannot read status history from %s - %v", hs.config.File, err)
+	}
+	if len(snapshots) > len(hs.snapshots) {
+		snapshots = snapshots[len(snapshots)-len(hs.snapshots):]
+	}
+	hs.next = copy(hs.snapshots, snapshots)
+	if hs.next == len(hs.snapshots) {
+		hs.next = 0
+		hs.full = true
+	}
+	return nil
+}
+
+// HandleHistoryRequest handles the request for the status history, which is sent as a JSON array of
+// snapshots, oldest first.  The optional query parameter "since", a time in RFC3339 format, limits the
+// result to later snapshots.  If the history hasn't been started the response is 404 Not Found.
+func (r *Reporter) HandleHistoryRequest(writer http.ResponseWriter, request *http.Request) {
+	state := r.state()
+	state.mutex.Lock()
+	running := state.history != nil
+	state.mutex.Unlock()
+	if !running {
+		http.Error(writer, "this service does not keep a status history", http.StatusNotFound)
+		return
+	}
+
+	history := r.History()
+	if value := request.URL.Query().Get("since"); value != "" {
+		since, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("invalid time \"%s\", must be RFC3339", value), http.StatusBadRequest)
+			return
+		}
+		var later []Snapshot
+		for _, snapshot := range history {
+			if snapshot.Time.After(since) {
+				later = append(later, snapshot)
+			}
+		}
+		history = later
+	}
+	if history == nil {
+		history = []Snapshot{}
+	}
+
+	body, err := json.Marshal(history)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding status history - %s\n", err.Error())
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(body)
+}
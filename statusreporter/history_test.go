@@ -0,0 +1,168 @@
+package statusreporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+	ts "github.com/goblimey/go-tools/testsupport"
+)
+
+// TestHistory checks that the history keeps the latest snapshots, oldest first, and serves them.
+func TestHistory(t *testing.T) {
+	start := time.Date(2020, time.February, 14, 15, 0, 0, 0, time.UTC)
+	cl := clock.NewManualClock(start)
+	reporter := MakeReporter(new(MetricsFeedForTest), "localhost", 0)
+	reporter.SetClock(cl)
+
+	// Before the history is started there is nothing to serve.
+	request := httptest.NewRequest("GET", "/status/history", nil)
+	recorder := httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+
+	err := reporter.StartHistory(HistoryConfig{Interval: time.Minute, Size: 3})
+	if err != nil {
+		t.Fatalf("StartHistory failed - %v", err)
+	}
+	defer reporter.StopHistory()
+	err = reporter.StartHistory(HistoryConfig{Interval: time.Minute, Size: 3})
+	if err != ErrHistoryRunning {
+		t.Errorf("expected ErrHistoryRunning, got %v", err)
+	}
+
+	// A snapshot is taken at the start and then every minute.  The fourth pushes out the first.
+	waitForSnapshot(t, &reporter, start)
+	for i := 1; i <= 3; i++ {
+		cl.BlockUntil(1)
+		cl.Advance(time.Minute)
+		waitForSnapshot(t, &reporter, start.Add(time.Duration(i)*time.Minute))
+	}
+
+	history := reporter.History()
+	if len(history) != 3 {
+		t.Fatalf("expected 3 snapshots, got %d", len(history))
+	}
+	for i, snapshot := range history {
+		expected := start.Add(time.Duration(i+1) * time.Minute)
+		if !snapshot.Time.Equal(expected) {
+			t.Errorf("snapshot %d: expected time %v, got %v", i, expected, snapshot.Time)
+		}
+	}
+	if history[0].Report != "foo" {
+		t.Errorf("expected report \"foo\", got \"%s\"", history[0].Report)
+	}
+	if history[0].Metrics[`test_bytes_total{direction="in"}`] != 42 {
+		t.Errorf("expected the metrics in the snapshot, got %v", history[0].Metrics)
+	}
+
+	request = httptest.NewRequest("GET", "/status/history?since=2020-02-14T15:02:00Z", nil)
+	recorder = httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	var served []Snapshot
+	err = json.Unmarshal(recorder.Body.Bytes(), &served)
+	if err != nil {
+		t.Fatalf("cannot decode %s - %v", recorder.Body.String(), err)
+	}
+	if len(served) != 1 || !served[0].Time.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("expected the snapshot taken at 15:03, got %s", recorder.Body.String())
+	}
+
+	request = httptest.NewRequest("GET", "/status/history?since=junk", nil)
+	recorder = httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+// TestHistoryStructured checks that the snapshot holds the structured status if the feed supplies one.
+func TestHistoryStructured(t *testing.T) {
+	reporter := MakeReporter(new(StructuredReportFeedForTest), "localhost", 0)
+	start := time.Date(2020, time.February, 14, 15, 0, 0, 0, time.UTC)
+	reporter.SetClock(clock.NewManualClock(start))
+	err := reporter.StartHistory(HistoryConfig{Interval: time.Minute, Size: 3})
+	if err != nil {
+		t.Fatalf("StartHistory failed - %v", err)
+	}
+	defer reporter.StopHistory()
+
+	waitForSnapshot(t, &reporter, start)
+	snapshot := reporter.History()[0]
+	if snapshot.Report != "" || string(snapshot.Structured) != `{"connections":2,"name":"foo"}` {
+		t.Errorf("expected the structured status, got %q and %q", snapshot.Report, snapshot.Structured)
+	}
+}
+
+// TestHistoryPersists checks that the history is kept in a file and loaded again after a restart.
+func TestHistoryPersists(t *testing.T) {
+	testDirName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(testDirName)
+
+	start := time.Date(2020, time.February, 14, 15, 0, 0, 0, time.UTC)
+	cl := clock.NewManualClock(start)
+	config := HistoryConfig{Interval: time.Minute, Size: 2, File: "history.json"}
+
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	reporter.SetClock(cl)
+	err = reporter.StartHistory(config)
+	if err != nil {
+		t.Fatalf("StartHistory failed - %v", err)
+	}
+	waitForSnapshot(t, &reporter, start)
+	cl.BlockUntil(1)
+	cl.Advance(time.Minute)
+	waitForSnapshot(t, &reporter, start.Add(time.Minute))
+	reporter.StopHistory()
+
+	// A new Reporter picks up the saved history and adds to it, dropping the oldest snapshot.
+	cl.Advance(time.Minute)
+	reporter = MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	reporter.SetClock(cl)
+	err = reporter.StartHistory(config)
+	if err != nil {
+		t.Fatalf("StartHistory failed - %v", err)
+	}
+	defer reporter.StopHistory()
+	waitForSnapshot(t, &reporter, start.Add(2*time.Minute))
+
+	history := reporter.History()
+	if len(history) != 2 ||
+		!history[0].Time.Equal(start.Add(time.Minute)) || !history[1].Time.Equal(start.Add(2*time.Minute)) {
+
+		t.Errorf("expected the snapshots from 15:01 and 15:02, got %v", history)
+	}
+}
+
+// TestHistoryConfig checks that an invalid config is rejected.
+func TestHistoryConfig(t *testing.T) {
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	for _, config := range []HistoryConfig{{Interval: 0, Size: 3}, {Interval: time.Minute, Size: 0}} {
+		if reporter.StartHistory(config) == nil {
+			t.Errorf("expected an error from %v", config)
+		}
+	}
+}
+
+// waitForSnapshot waits until the newest snapshot in the history is the one taken at the given time.
+func waitForSnapshot(t *testing.T, reporter *Reporter, taken time.Time) {
+	for i := 0; i < 500; i++ {
+		history := reporter.History()
+		if len(history) > 0 && history[len(history)-1].Time.Equal(taken) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no snapshot was taken at %v", taken)
+}
@@ -0,0 +1,170 @@
+package statusreporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+)
+
+// LogLevelFeed is an optional interface that a ReportFeedT may also implement.  GetLogLevel returns the
+// current log level, which the Reporter serves at GET /{service}/loglevel and shows on the report page.
+type LogLevelFeed interface {
+	GetLogLevel() uint8
+}
+
+// LogLevelStatus describes the current log level and any pending revert.
+type LogLevelStatus struct {
+	Level    uint8      `json:"level"`
+	RevertTo *uint8     `json:"revertTo,omitempty"` // The level that will be restored, if a revert is pending.
+	RevertAt *time.Time `json:"revertAt,omitempty"` // When the level will be restored.
+}
+
+// String returns a description of the log level, for example "3" or "3 until 15:04:05, then 0".
+func (s LogLevelStatus) String() string {
+	if s.RevertTo == nil || s.RevertAt == nil {
+		return strconv.Itoa(int(s.Level))
+	}
+	return fmt.Sprintf("%d until %s, then %d", s.Level, s.RevertAt.Format("15:04:05"), *s.RevertTo)
+}
+
+// logLevelState holds the last log level set through the Reporter and any pending revert.
+type logLevelState struct {
+	level       uint8
+	levelKnown  bool
+	revertTimer clock.Timer
+	revertTo    uint8
+	revertAt    time.Time
+	// generation is incremented by each change, so that a revert that was cancelled too late does nothing.
+	generation int
+}
+
+// SetClock sets the clock used to time log level reverts, the status history, the stream snapshots and the
+// Reporter's events.  It's intended for use in tests.  The default is the system clock.
+func (r *Reporter) SetClock(cl clock.TimerClock) {
+	r.ClockPriv = cl
+}
+
+// timerClock returns the Reporter's clock, by default the system clock.
+func (r *Reporter) timerClock() clock.TimerClock {
+	if r.ClockPriv == nil {
+		return &clock.SystemClock{}
+	}
+	return r.ClockPriv
+}
+
+// ChangeLogLevel sets the feed's log level.  If duration is greater than zero, the level is set back to
+// revertTo when the duration has passed.  Any pending revert from an earlier change is cancelled.
+func (r *Reporter) ChangeLogLevel(level uint8, duration time.Duration, revertTo uint8) {
+	cl := r.timerClock()
+
+	state := r.state()
+	state.mutex.Lock()
+	logLevel := &state.logLevel
+	if logLevel.revertTimer != nil {
+		logLevel.revertTimer.Stop()
+		logLevel.revertTimer = nil
+	}
+	logLevel.generation++
+	generation := logLevel.generation
+	logLevel.level = level
+	logLevel.levelKnown = true
+	if duration > 0 {
+		logLevel.revertTo = revertTo
+		logLevel.revertAt = cl.Now().Add(duration)
+		logLevel.revertTimer = cl.AfterFunc(duration, func() { r.revertLogLevel(generation) })
+	}
+	state.mutex.Unlock()
+
+	r.ReportFeedPriv.SetLogLevel(level)
+	r.publish(Event{Type: "loglevel", Data: r.logLevelStatus()})
+}
+
+// revertLogLevel restores the log level at the end of a timed change, unless there has been another change
+// since.
+func (r *Reporter) revertLogLevel(generation int) {
+	state := r.state()
+	state.mutex.Lock()
+	logLevel := &state.logLevel
+	if logLevel.generation != generation {
+		state.mutex.Unlock()
+		return
+	}
+	logLevel.revertTimer = nil
+	logLevel.generation++
+	level := logLevel.revertTo
+	logLevel.level = level
+	state.mutex.Unlock()
+
+	r.ReportFeedPriv.SetLogLevel(level)
+	r.publish(Event{Type: "loglevel", Data: r.logLevelStatus()})
+}
+
+// LogLevel returns the current log level and any pending revert.  The level comes from the feed if it
+// implements LogLevelFeed, otherwise it's the last level set through the Reporter.  The result is false if
+// the level is not known.
+func (r *Reporter) LogLevel() (LogLevelStatus, bool) {
+	state := r.state()
+	state.mutex.Lock()
+	logLevel := state.logLevel
+	state.mutex.Unlock()
+
+	var status LogLevelStatus
+	if feed, ok := r.ReportFeedPriv.(LogLevelFeed); ok {
+		status.Level = feed.GetLogLevel()
+	} else if logLevel.levelKnown {
+		status.Level = logLevel.level
+	} else {
+		return status, false
+	}
+	if logLevel.revertTimer != nil {
+		revertTo := logLevel.revertTo
+		revertAt := logLevel.revertAt
+		status.RevertTo = &revertTo
+		status.RevertAt = &revertAt
+	}
+	return status, true
+}
+
+// logLevelStatus returns the log level status, or nil if it's not known.
+func (r *Reporter) logLevelStatus() *LogLevelStatus {
+	status, ok := r.LogLevel()
+	if !ok {
+		return nil
+	}
+	return &status
+}
+
+// HandleGetLogLevelRequest responds to a GET /{servicename}/loglevel HTTP request with the current log
+// level - as plain text, for example "3" or "3 until 15:04:05, then 0", or as JSON if the request's Accept
+// header prefers it.  If the level is not known the response is 404 Not Found.
+func (r *Reporter) HandleGetLogLevelRequest(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writer.Header().Set("Allow", "GET, HEAD")
+		http.Error(writer, "use POST or PUT /{service}/loglevel/{level} to set the log level",
+			http.StatusMethodNotAllowed)
+		return
+	}
+	status, ok := r.LogLevel()
+	if !ok {
+		http.Error(writer, "this service does not report its log level", http.StatusNotFound)
+		return
+	}
+	if prefersJSON(request.Header.Get("Accept")) {
+		body, err := json.Marshal(status)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error encoding log level - %s\n", err.Error())
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(body)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(writer, status.String())
+}
@@ -0,0 +1,102 @@
+package statusreporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+)
+
+// TestGetLogLevelRequest checks that the log level can be read and that it can only be set using POST or
+// PUT.
+func TestGetLogLevelRequest(t *testing.T) {
+	reportFeed := new(ReportFeedForTest)
+	reporter := MakeReporter(reportFeed, "localhost", 0)
+
+	var testData = []struct {
+		method         string
+		target         string
+		accept         string
+		expectedStatus int
+		expectedBody   string
+		expectedLevel  uint8
+	}{
+		{"GET", "/status/loglevel", "", http.StatusOK, "0\n", 0},
+		{"GET", "/status/loglevel/3", "", http.StatusMethodNotAllowed, "", 0},
+		{"DELETE", "/status/loglevel/3", "", http.StatusMethodNotAllowed, "", 0},
+		{"PUT", "/status/loglevel/3", "", http.StatusOK, "", 3},
+		{"GET", "/status/loglevel/", "", http.StatusOK, "3\n", 3},
+		{"POST", "/status/loglevel", "", http.StatusMethodNotAllowed, "", 3},
+		{"POST", "/status/loglevel/2", "", http.StatusOK, "", 2},
+		{"GET", "/status/loglevel", "application/json", http.StatusOK, `{"level":2}`, 2},
+	}
+
+	for _, td := range testData {
+		request := httptest.NewRequest(td.method, td.target, nil)
+		if td.accept != "" {
+			request.Header.Set("Accept", td.accept)
+		}
+		recorder := httptest.NewRecorder()
+		reporter.ServeHTTP(recorder, request)
+		if recorder.Code != td.expectedStatus {
+			t.Errorf("%s %s: expected status %d, got %d", td.method, td.target, td.expectedStatus, recorder.Code)
+		}
+		if td.expectedBody != "" && recorder.Body.String() != td.expectedBody {
+			t.Errorf("%s %s: expected body %q, got %q", td.method, td.target, td.expectedBody, recorder.Body.String())
+		}
+		if reportFeed.GetLogLevel() != td.expectedLevel {
+			t.Errorf("%s %s: expected level %d, got %d", td.method, td.target, td.expectedLevel, reportFeed.GetLogLevel())
+		}
+	}
+}
+
+// TestTimedLogLevel checks that a timed change of log level is reverted.
+func TestTimedLogLevel(t *testing.T) {
+	start := time.Date(2020, time.February, 14, 15, 0, 0, 0, time.UTC)
+	cl := clock.NewManualClock(start)
+	reportFeed := new(ReportFeedForTest)
+	reporter := MakeReporter(reportFeed, "localhost", 0)
+	reporter.SetClock(cl)
+
+	post := func(target string, expectedStatus int) {
+		request := httptest.NewRequest("POST", target, nil)
+		recorder := httptest.NewRecorder()
+		reporter.ServeHTTP(recorder, request)
+		if recorder.Code != expectedStatus {
+			t.Errorf("POST %s: expected status %d, got %d", target, expectedStatus, recorder.Code)
+		}
+	}
+
+	post("/status/loglevel/3?for=junk", http.StatusBadRequest)
+	post("/status/loglevel/3?then=1", http.StatusBadRequest)
+
+	post("/status/loglevel/1", http.StatusOK)
+	post("/status/loglevel/3?for=10m", http.StatusOK)
+	status, _ := reporter.LogLevel()
+	if status.String() != "3 until 15:10:00, then 1" {
+		t.Errorf("expected \"3 until 15:10:00, then 1\", got \"%s\"", status.String())
+	}
+
+	cl.Advance(10 * time.Minute)
+	if reportFeed.GetLogLevel() != 1 {
+		t.Errorf("expected the level to revert to 1, got %d", reportFeed.GetLogLevel())
+	}
+	status, _ = reporter.LogLevel()
+	if status.RevertTo != nil {
+		t.Errorf("expected no pending revert, got %s", status.String())
+	}
+
+	// A later change cancels the revert.
+	post("/status/loglevel/3?for=10m&then=0", http.StatusOK)
+	cl.Advance(5 * time.Minute)
+	post("/status/loglevel/2", http.StatusOK)
+	cl.Advance(10 * time.Minute)
+	if reportFeed.GetLogLevel() != 2 {
+		t.Errorf("expected the level to stay at 2, got %d", reportFeed.GetLogLevel())
+	}
+	if cl.WaiterCount() != 0 {
+		t.Errorf("expected no timers, got %d", cl.WaiterCount())
+	}
+}
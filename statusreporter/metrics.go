@@ -0,0 +1,161 @@
+package statusreporter
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetricsRequestEnd defines the end of the HTTP request for the metrics.
+const MetricsRequestEnd = "/metrics"
+
+// metricsContentType is the content type of the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// processStart is the time that the process started, near enough.
+var processStart = time.Now()
+
+// MetricType is the type of a Metric.
+type MetricType int
+
+const (
+	// Counter is a value that only goes up, such as the number of bytes received.
+	Counter MetricType = iota
+	// Gauge is a value that can go up and down, such as the number of open connections.
+	Gauge
+)
+
+// String returns the name of the type used in the exposition format.
+func (mt MetricType) String() string {
+	if mt == Counter {
+		return "counter"
+	}
+	return "gauge"
+}
+
+// Metric is a single value.  Metrics with the same name must have the same type and help text, and
+// different labels.
+type Metric struct {
+	Name   string            // The name, for example "proxy_connections_total".
+	Help   string            // A description of the metric.
+	Type   MetricType        // Counter or Gauge.
+	Labels map[string]string // Optional labels, for example {"direction": "client_to_server"}.
+	Value  float64           // The current value.
+}
+
+// MetricsFeed is an optional interface that a ReportFeedT may also implement.  Metrics returns the current
+// values of the feed's counters and gauges, which the Reporter serves at /{service}/metrics in Prometheus
+// text exposition format, along with its own process metrics.
+type MetricsFeed interface {
+	Metrics() []Metric
+}
+
+// HandleMetricsRequest handles the request for the metrics.
+func (r *Reporter) HandleMetricsRequest(writer http.ResponseWriter, request *http.Request) {
+	metrics := processMetrics()
+	if feed, ok := r.ReportFeedPriv.(MetricsFeed); ok {
+		metrics = append(metrics, feed.Metrics()...)
+	}
+	writer.Header().Set("Content-Type", metricsContentType)
+	err := WriteMetrics(writer, metrics)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing metrics - %s\n", err.Error())
+	}
+}
+
+// WriteMetrics writes metrics in Prometheus text exposition format.  Metrics with the same name are written
+// together, under one HELP and TYPE line, in the order in which the name first appears.
+func WriteMetrics(w io.Writer, metrics []Metric) error {
+	var names []string
+	byName := make(map[string][]Metric)
+	for _, metric := range metrics {
+		if _, ok := byName[metric.Name]; !ok {
+			names = append(names, metric.Name)
+		}
+		byName[metric.Name] = append(byName[metric.Name], metric)
+	}
+
+	var b strings.Builder
+	for _, name := range names {
+		family := byName[name]
+		if family[0].Help != "" {
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, escapeHelp(family[0].Help))
+		}
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, family[0].Type)
+		for _, metric := range family {
+			b.WriteString(name)
+			writeLabels(&b, metric.Labels)
+			b.WriteString(" ")
+			b.WriteString(strconv.FormatFloat(metric.Value, 'g', -1, 64))
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeLabels writes a set of labels in the form {name="value",...}, sorted by name.
+func writeLabels(b *strings.Builder, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(b, "%s=\"%s\"", name, escapeLabelValue(labels[name]))
+	}
+	b.WriteString("}")
+}
+
+// escapeHelp escapes backslashes and newlines in help text.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabelValue escapes backslashes, double quotes and newlines in a label value.
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// processMetrics returns the built-in metrics describing the process.  The number of open file descriptors
+// is only available on systems that have /proc/self/fd.
+func processMetrics() []Metric {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	metrics := []Metric{
+		{Name: "process_start_time_seconds", Help: "Start time of the process since the Unix epoch in seconds.",
+			Type: Gauge, Value: float64(processStart.UnixNano()) / 1e9},
+		{Name: "process_uptime_seconds", Help: "Time since the process started in seconds.",
+			Type: Gauge, Value: time.Since(processStart).Seconds()},
+		{Name: "go_goroutines", Help: "Number of goroutines that currently exist.",
+			Type: Gauge, Value: float64(runtime.NumGoroutine())},
+		{Name: "go_memstats_heap_alloc_bytes", Help: "Number of heap bytes allocated and still in use.",
+			Type: Gauge, Value: float64(memStats.HeapAlloc)},
+		{Name: "go_memstats_heap_sys_bytes", Help: "Number of heap bytes obtained from the system.",
+			Type: Gauge, Value: float64(memStats.HeapSys)},
+	}
+
+	files, err := ioutil.ReadDir("/proc/self/fd")
+	if err == nil {
+		metrics = append(metrics, Metric{Name: "process_open_fds", Help: "Number of open file descriptors.",
+			Type: Gauge, Value: float64(len(files))})
+	}
+
+	return metrics
+}
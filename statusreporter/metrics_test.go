@@ -0,0 +1,58 @@
+package statusreporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestWriteMetrics checks the text exposition format.
+func TestWriteMetrics(t *testing.T) {
+	const expected = `# HELP test_bytes_total Bytes seen.
+# TYPE test_bytes_total counter
+test_bytes_total{direction="in"} 42
+test_bytes_total{direction="out",peer="a \"b\""} 7
+# TYPE test_open gauge
+test_open 1.5
+`
+	var b strings.Builder
+	err := WriteMetrics(&b, new(MetricsFeedForTest).Metrics())
+	if err != nil {
+		t.Fatalf("WriteMetrics failed - %v", err)
+	}
+	if b.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
+
+// TestMetricsRequest checks that the metrics request returns the process metrics and the feed's metrics.
+func TestMetricsRequest(t *testing.T) {
+	reporter := MakeReporter(new(MetricsFeedForTest), "localhost", 0)
+	request := httptest.NewRequest("GET", "/status/metrics", nil)
+	recorder := httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, recorder.Code)
+	}
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %s", recorder.Header().Get("Content-Type"))
+	}
+	body := recorder.Body.String()
+	for _, want := range []string{"\nprocess_uptime_seconds ", "\ngo_goroutines ",
+		"\ngo_memstats_heap_alloc_bytes ", "\ntest_bytes_total{direction=\"in\"} 42\n"} {
+
+		if !strings.Contains(body, want) {
+			t.Errorf("expected the metrics to contain %q, got\n%s", want, body)
+		}
+	}
+
+	// A feed that doesn't supply metrics still gets the process metrics.
+	reporter = MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	recorder = httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, request)
+	if !strings.Contains(recorder.Body.String(), "# TYPE go_goroutines gauge\n") {
+		t.Errorf("expected the process metrics, got\n%s", recorder.Body.String())
+	}
+}
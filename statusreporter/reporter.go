@@ -1,11 +1,17 @@
 package statusreporter
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
 
 	htmlTemplate "html/template"
 	textTemplate "text/template"
@@ -20,6 +26,10 @@ const StylesheetRequestEnd = "/stylesheet.css"
 // StatusRequestEnd defines the end of the HTTP request for the status page.
 const StatusRequestEnd = "/report"
 
+// JSONStatusRequestEnd defines the end of the HTTP request for the status report
+// in JSON.
+const JSONStatusRequestEnd = "/report.json"
+
 // LogLevelRequestMiddle defines the middle part of the HTTP loglevel request.
 const LogLevelRequestMiddle = "/loglevel/"
 
@@ -31,11 +41,22 @@ type ReportFeedT interface {
 	Status() []byte
 }
 
+// StructuredReportFeed is an optional interface that a ReportFeedT may also
+// implement.  StructuredStatus returns the status as a Go value, which the
+// Reporter serves as JSON at /{service}/report.json, and at /{service}/report
+// if the request's Accept header prefers JSON to HTML.
+type StructuredReportFeed interface {
+	StructuredStatus() interface{}
+}
+
 // StatusReport contains the data for the status report page.
 type StatusReport struct {
 	PageTitle   string
+	PathPrefix  string
 	ServiceName string
 	Content     string
+	LogLevel    string
+	Commands    []Command
 }
 
 // Reporter provides the reporting API.
@@ -50,10 +71,33 @@ type Reporter struct {
 	ServiceHostPriv string
 	// ServicePortPriv is the name of the http port.
 	ServicePortPriv int
+	// PathPrefixPriv is the path under which the Reporter is mounted in another server, eg "/admin".
+	// It's used to build the links in the pages.  The default is "".
+	PathPrefixPriv string
 	// StylesheetRequestPriv defines the HTTP request for the stylesheet
 	StylesheetRequestPriv string
 	// StatusRequestPriv defines the name of the status request, eg /status/report
 	StatusRequestPriv string
+	// JSONStatusRequestPriv defines the name of the JSON status request, eg /status/report.json
+	JSONStatusRequestPriv string
+	// MetricsRequestPriv defines the name of the metrics request, eg /status/metrics
+	MetricsRequestPriv string
+	// CommandRequestPriv defines the start of the command request, eg "/status/command/".
+	CommandRequestPriv string
+	// CommandsPriv are the commands registered by the embedding program.
+	CommandsPriv []Command
+	// HealthRequestPriv defines the name of the liveness probe request, eg /status/healthz
+	HealthRequestPriv string
+	// ReadyRequestPriv defines the name of the readiness probe request, eg /status/readyz
+	ReadyRequestPriv string
+	// HistoryRequestPriv defines the name of the status history request, eg /status/history
+	HistoryRequestPriv string
+	// StreamRequestPriv defines the name of the request for the event stream, eg /status/stream
+	StreamRequestPriv string
+	// LiveRequestPriv defines the name of the request for the live status page, eg /status/live
+	LiveRequestPriv string
+	// StreamIntervalPriv is the interval between the status snapshots sent on the event stream.
+	StreamIntervalPriv time.Duration
 	// LogLevelRequestPriv defines the start of the set log level request, eg "/status/loglevel/".
 	LogLevelRequestPriv string
 	// LogLevelRequestRE is the regular expression defining the log level request including the level number,
@@ -65,17 +109,36 @@ type Reporter struct {
 	HTMLReportTemplate *htmlTemplate.Template
 	// ErrorTemplate is the html template for the error page.
 	ErrorTemplate *htmlTemplate.Template
+	// LiveTemplate is the html template for the live status page.
+	LiveTemplate *htmlTemplate.Template
+	// AuthenticatorsPriv identify the senders of requests.  If there are none, all requests are allowed.
+	AuthenticatorsPriv []Authenticator
+	// ReadRolePriv is the role required by the read-only requests, eg the status report.  Default is "read".
+	ReadRolePriv string
+	// ControlRolePriv is the role required by the control requests, eg setting the log level.  Default is "control".
+	ControlRolePriv string
+	// ClockPriv times the log level reverts, the status history, the stream snapshots and the Reporter's
+	// events.  Default is nil - the system clock.
+	ClockPriv clock.TimerClock
+	// TLSConfigPriv makes the server started by Start serve HTTPS.  Default is nil - plain HTTP.
+	TLSConfigPriv *tls.Config
+
+	// server holds the state of the HTTP server.  It's shared by copies of the Reporter.
+	server *serverState
 }
 
 // MakeReporter creates and returns a reporter object
 func MakeReporter(reportFeed ReportFeedT, host string, port int) Reporter {
 	var reporter Reporter
+	reporter.server = new(serverState)
 	reporter.InitTemplates()
 	reporter.SetReportFeed(reportFeed)
 	reporter.SetServiceName(DefaultServiceNamePriv)
 	reporter.SetServiceHost(host)
 	reporter.SetServicePort(port)
 	reporter.SetRequests() // set to default values - "/status/...."
+	reporter.SetReadRole(RoleRead)
+	reporter.SetControlRole(RoleControl)
 	return reporter
 }
 
@@ -95,6 +158,12 @@ func (r *Reporter) SetServiceName(name string) {
 	r.SetRequests()
 }
 
+// SetPathPrefix sets the path under which the Reporter is mounted in another server, for example "/admin".
+// The caller is responsible for stripping the prefix from requests, for example using http.StripPrefix.
+func (r *Reporter) SetPathPrefix(prefix string) {
+	r.PathPrefixPriv = strings.TrimSuffix(prefix, "/")
+}
+
 // SetServiceHost sets the hostname that web service answers to.
 func (r *Reporter) SetServiceHost(host string) {
 	r.ServiceHostPriv = host
@@ -106,12 +175,22 @@ func (r *Reporter) SetServicePort(port int) {
 }
 
 // HandleStatusRequest handles the request for a status report by displaying the last input and output buffers.
+// If the report feed supplies a structured status and the request's Accept header prefers JSON to HTML, the
+// report is sent as JSON.
 func (r *Reporter) HandleStatusRequest(writer http.ResponseWriter, request *http.Request) {
+	if _, ok := r.ReportFeedPriv.(StructuredReportFeed); ok && prefersJSON(request.Header.Get("Accept")) {
+		r.HandleJSONStatusRequest(writer, request)
+		return
+	}
 	if r.TextReportTemplate == nil {
 		r.InitTemplates()
 	}
 	body := string(r.ReportFeedPriv.Status())
-	statusReport := StatusReport{"Status", r.ServiceNamePriv, body}
+	var logLevel string
+	if status, ok := r.LogLevel(); ok {
+		logLevel = status.String()
+	}
+	statusReport := StatusReport{"Status", r.PathPrefixPriv, r.ServiceNamePriv, body, logLevel, r.Commands()}
 	if r.UseTextTemplates {
 		// The supplied r.ReportFeedPriv.Status() value is expected to contain
 		// HTML tags so we need to use the less secure text template.  That
@@ -146,6 +225,25 @@ func (r *Reporter) HandleStatusRequest(writer http.ResponseWriter, request *http
 	return
 }
 
+// HandleJSONStatusRequest handles the request for a status report in JSON.  The report feed must implement
+// StructuredReportFeed.  If it doesn't, the response is 404 Not Found.
+func (r *Reporter) HandleJSONStatusRequest(writer http.ResponseWriter, request *http.Request) {
+	feed, ok := r.ReportFeedPriv.(StructuredReportFeed)
+	if !ok {
+		http.Error(writer, "this service does not supply a JSON status report", http.StatusNotFound)
+		return
+	}
+	body, err := json.Marshal(feed.StructuredStatus())
+	if err != nil {
+		em := fmt.Sprintf("error encoding status report - %s", err.Error())
+		fmt.Fprintf(os.Stderr, "%s\n", em)
+		http.Error(writer, em, http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(body)
+}
+
 // HandleStylesheetRequest handles an HTTP request for the stylesheet.
 func (r *Reporter) HandleStylesheetRequest(writer http.ResponseWriter, request *http.Request) {
 	_, err := writer.Write(stylesheetPage)
@@ -156,11 +254,24 @@ func (r *Reporter) HandleStylesheetRequest(writer http.ResponseWriter, request *
 	return
 }
 
-// HandleLogLevelRequest responds to a /{servicename}/Loglevel/{level} HTTP request.
+// HandleLogLevelRequest responds to a /{servicename}/loglevel/{level} HTTP request, which must use POST or
+// PUT.  The optional query parameters "for" and "then" change the level for a while and then set it back,
+// for example "/status/loglevel/3?for=10m&then=0".  "then" defaults to the current level, or 0 if that's
+// not known.  A GET request for "/{servicename}/loglevel/" is passed to HandleGetLogLevelRequest.
 func (r *Reporter) HandleLogLevelRequest(writer http.ResponseWriter, request *http.Request) {
 	url := request.URL
-	// The uri is something like "/{servicename}/Loglevel/42".  We want just the "42".
-	part := r.LogLevelRequestRE.FindStringSubmatch(url.RequestURI())
+	// The uri is something like "/{servicename}/loglevel/42?for=10m".  We want just the "42".
+	uri := strings.SplitN(url.RequestURI(), "?", 2)[0]
+	if uri == r.LogLevelRequestPriv && (request.Method == http.MethodGet || request.Method == http.MethodHead) {
+		r.HandleGetLogLevelRequest(writer, request)
+		return
+	}
+	if request.Method != http.MethodPost && request.Method != http.MethodPut {
+		writer.Header().Set("Allow", "POST, PUT")
+		http.Error(writer, "the log level must be set using POST or PUT", http.StatusMethodNotAllowed)
+		return
+	}
+	part := r.LogLevelRequestRE.FindStringSubmatch(uri)
 	if len(part) <= 1 {
 		fmt.Fprintf(os.Stderr, "illegal level in log level request - %s\n", url.RequestURI())
 		writer.WriteHeader(400)
@@ -178,25 +289,32 @@ func (r *Reporter) HandleLogLevelRequest(writer http.ResponseWriter, request *ht
 		}
 		return
 	}
-	r.ReportFeedPriv.SetLogLevel(uint8(level))
-}
 
-// StartService starts the web service.
-func (r Reporter) StartService() {
-	if r.TextReportTemplate == nil {
-		r.InitTemplates()
+	query := url.Query()
+	var duration time.Duration
+	if value := query.Get("for"); value != "" {
+		duration, err = time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			http.Error(writer, fmt.Sprintf("invalid duration \"%s\" in log level request", value),
+				http.StatusBadRequest)
+			return
+		}
 	}
-	// Set the HTTP request handlers.
-	http.HandleFunc(r.StatusRequestPriv, r.HandleStatusRequest)
-	http.HandleFunc(r.StylesheetRequestPriv, r.HandleStylesheetRequest)
-	http.HandleFunc(r.LogLevelRequestPriv, r.HandleLogLevelRequest)
-
-	server := fmt.Sprintf("%s:%d", r.ServiceHostPriv, r.ServicePortPriv)
-	fmt.Fprintf(os.Stderr, "listening for status requests on %s\n", server)
-	err := http.ListenAndServe(server, nil)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "cannot start http server for status requests - %s\n"+err.Error())
+	var revertTo uint8
+	if current, ok := r.LogLevel(); ok {
+		revertTo = current.Level
 	}
+	if value := query.Get("then"); value != "" {
+		then, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || duration == 0 {
+			http.Error(writer, fmt.Sprintf("invalid level \"%s\" in log level request, must be 0-255 and needs \"for\"",
+				value), http.StatusBadRequest)
+			return
+		}
+		revertTo = uint8(then)
+	}
+
+	r.ChangeLogLevel(uint8(level), duration, revertTo)
 }
 
 // SetRequests sets the names and expressions defining the HTTP requests.
@@ -209,6 +327,22 @@ func (r *Reporter) SetRequests() {
 	r.StylesheetRequestPriv = "/" + r.ServiceNamePriv + StylesheetRequestEnd
 	// eg "/status/report".
 	r.StatusRequestPriv = "/" + r.ServiceNamePriv + StatusRequestEnd
+	// eg "/status/report.json".
+	r.JSONStatusRequestPriv = "/" + r.ServiceNamePriv + JSONStatusRequestEnd
+	// eg "/status/metrics".
+	r.MetricsRequestPriv = "/" + r.ServiceNamePriv + MetricsRequestEnd
+	// eg "/status/command/".
+	r.CommandRequestPriv = "/" + r.ServiceNamePriv + CommandRequestMiddle
+	// eg "/status/healthz".
+	r.HealthRequestPriv = "/" + r.ServiceNamePriv + HealthRequestEnd
+	// eg "/status/readyz".
+	r.ReadyRequestPriv = "/" + r.ServiceNamePriv + ReadyRequestEnd
+	// eg "/status/history".
+	r.HistoryRequestPriv = "/" + r.ServiceNamePriv + HistoryRequestEnd
+	// eg "/status/stream".
+	r.StreamRequestPriv = "/" + r.ServiceNamePriv + StreamRequestEnd
+	// eg "/status/live".
+	r.LiveRequestPriv = "/" + r.ServiceNamePriv + LiveRequestEnd
 	// eg "/status/loglevel/"
 	r.LogLevelRequestPriv = "/" + r.ServiceNamePriv + LogLevelRequestMiddle
 	// eg "^/status/loglevel/([0-9]+)$"
@@ -226,4 +360,56 @@ func (r *Reporter) InitTemplates() {
 
 	r.ErrorTemplate = htmlTemplate.New("error")
 	r.ErrorTemplate = htmlTemplate.Must(r.ErrorTemplate.Parse(errorText + baseText))
+
+	r.LiveTemplate = htmlTemplate.New("live")
+	r.LiveTemplate = htmlTemplate.Must(r.LiveTemplate.Parse(liveText + baseText))
+}
+
+// prefersJSON returns true if an HTTP Accept header gives application/json a
+// higher quality value than text/html.  A missing header prefers HTML.
+func prefersJSON(accept string) bool {
+	jsonQuality := acceptQuality(accept, "application", "json")
+	htmlQuality := acceptQuality(accept, "text", "html")
+	return jsonQuality > htmlQuality
+}
+
+// acceptQuality returns the quality value that an HTTP Accept header gives to
+// a media type, taking the most specific matching range, or 0 if no range
+// matches.
+func acceptQuality(accept, mediaType, subType string) float64 {
+	quality := 0.0
+	specificity := -1
+	for _, mediaRange := range strings.Split(accept, ",") {
+		parts := strings.Split(mediaRange, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+
+		var s int
+		switch name {
+		case mediaType + "/" + subType:
+			s = 2
+		case mediaType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s < specificity {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err == nil {
+					q = v
+				}
+			}
+		}
+		specificity = s
+		quality = q
+	}
+	return quality
 }
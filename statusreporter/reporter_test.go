@@ -3,6 +3,7 @@ package statusreporter
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"regexp"
@@ -23,6 +24,7 @@ const format = `
  <h2>Status</h2>
  <section id="content">	
 foo
+<p id='loglevel'>Log level 0</p>
  </section>
  </body>
 </html>
@@ -198,3 +200,55 @@ func reduceString(str string) string {
 	return re.ReplaceAllString(str, " ")
 }
 
+// TestJSONStatusRequest checks the JSON status report and the content negotiation
+// on the report request.
+func TestJSONStatusRequest(t *testing.T) {
+	const expectedJSON = `{"connections":2,"name":"foo"}`
+
+	reporter := MakeReporter(new(StructuredReportFeedForTest), "foo", 42)
+
+	// The report.json request always produces JSON.
+	request := httptest.NewRequest("GET", "/status/report.json", nil)
+	recorder := httptest.NewRecorder()
+	reporter.HandleJSONStatusRequest(recorder, request)
+	if recorder.Body.String() != expectedJSON {
+		t.Errorf("expected %s, got %s", expectedJSON, recorder.Body.String())
+	}
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected content type application/json, got %s", recorder.Header().Get("Content-Type"))
+	}
+
+	var testData = []struct {
+		accept       string
+		expectedJSON bool
+	}{
+		{"", false},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"application/json", true},
+		{"application/json, text/html;q=0.5", true},
+		{"text/html;q=0.9, application/*", true},
+		{"*/*", false},
+	}
+
+	for _, td := range testData {
+		request = httptest.NewRequest("GET", "/status/report", nil)
+		if td.accept != "" {
+			request.Header.Set("Accept", td.accept)
+		}
+		recorder = httptest.NewRecorder()
+		reporter.HandleStatusRequest(recorder, request)
+		gotJSON := recorder.Body.String() == expectedJSON
+		if gotJSON != td.expectedJSON {
+			t.Errorf("Accept \"%s\": expected JSON %v, got body %s", td.accept, td.expectedJSON, recorder.Body.String())
+		}
+	}
+
+	// A report feed that doesn't supply a structured status produces 404.
+	reporter = MakeReporter(new(ReportFeedForTest), "foo", 42)
+	request = httptest.NewRequest("GET", "/status/report.json", nil)
+	recorder = httptest.NewRecorder()
+	reporter.HandleJSONStatusRequest(recorder, request)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+}
@@ -0,0 +1,237 @@
+package statusreporter
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrAlreadyStarted is returned by Start and Serve if the Reporter's server is already running.
+var ErrAlreadyStarted = errors.New("status reporter is already running")
+
+// ErrNoCertificate is returned by Start and Serve if the Reporter's TLS config has no certificate.
+var ErrNoCertificate = errors.New("status reporter TLS config has no certificate")
+
+// serverState holds the HTTP server run by Start and the handler used by ServeHTTP.  The Reporter holds a
+// pointer to it so that copies of the Reporter share it.
+type serverState struct {
+	mutex    sync.Mutex
+	server   *http.Server
+	listener net.Listener
+	mux      *http.ServeMux
+	muxName  string
+	// events carries the events produced by the Reporter itself.
+	events *Broadcaster
+	// shuttingDown is closed when the server begins to shut down, to end the event streams.
+	shuttingDown chan struct{}
+	// logLevel holds the last log level set through the Reporter and any pending revert.
+	logLevel logLevelState
+	// history takes the snapshots for the status history, if it has been started.
+	history *historySampler
+}
+
+// Reporter satisfies http.Handler.
+var _ http.Handler = (*Reporter)(nil)
+
+// state returns the Reporter's server state, creating it if necessary.
+func (r *Reporter) state() *serverState {
+	if r.server == nil {
+		r.server = new(serverState)
+	}
+	return r.server
+}
+
+// ServeMux returns a new ServeMux with the Reporter's request handlers registered on it.  The handlers check
+// that the sender of each request has the required role - see SetAuthenticators.
+func (r *Reporter) ServeMux() *http.ServeMux {
+	if r.TextReportTemplate == nil {
+		r.InitTemplates()
+	}
+	if len(r.StatusRequestPriv) == 0 {
+		r.SetRequests()
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(r.StatusRequestPriv, r.requireRole(r.readRole, r.HandleStatusRequest))
+	mux.HandleFunc(r.JSONStatusRequestPriv, r.requireRole(r.readRole, r.HandleJSONStatusRequest))
+	mux.HandleFunc(r.MetricsRequestPriv, r.requireRole(r.readRole, r.HandleMetricsRequest))
+	mux.HandleFunc(r.CommandRequestPriv, r.requireRole(r.controlRole, r.HandleCommandRequest))
+	mux.HandleFunc(r.HistoryRequestPriv, r.requireRole(r.readRole, r.HandleHistoryRequest))
+	mux.HandleFunc(r.StreamRequestPriv, r.requireRole(r.readRole, r.HandleStreamRequest))
+	mux.HandleFunc(r.LiveRequestPriv, r.requireRole(r.readRole, r.HandleLiveRequest))
+	mux.HandleFunc(r.StylesheetRequestPriv, r.HandleStylesheetRequest)
+	// The probes are open to anybody, so that the container platform can reach them.
+	mux.HandleFunc(r.HealthRequestPriv, r.HandleHealthRequest)
+	mux.HandleFunc(r.ReadyRequestPriv, r.HandleReadyRequest)
+	// Reading the log level needs the read role, with or without the trailing slash.  Setting it needs the
+	// control role.
+	readLogLevel := r.requireRole(r.readRole, r.HandleLogLevelRequest)
+	setLogLevel := r.requireRole(r.controlRole, r.HandleLogLevelRequest)
+	mux.HandleFunc(strings.TrimSuffix(r.LogLevelRequestPriv, "/"),
+		r.requireRole(r.readRole, r.HandleGetLogLevelRequest))
+	mux.HandleFunc(r.LogLevelRequestPriv, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodGet || request.Method == http.MethodHead {
+			readLogLevel(writer, request)
+			return
+		}
+		setLogLevel(writer, request)
+	})
+	return mux
+}
+
+// ServeHTTP satisfies http.Handler, so the Reporter can be mounted in an existing server.  To mount it under
+// a prefix, strip the prefix and tell the Reporter about it, for example:
+//
+//	reporter.SetPathPrefix("/admin")
+//	http.Handle("/admin/", http.StripPrefix("/admin", &reporter))
+func (r *Reporter) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	state := r.state()
+	state.mutex.Lock()
+	if state.mux == nil || state.muxName != r.ServiceNamePriv {
+		// First call, or the service name has changed since the handlers were registered.
+		state.mux = r.ServeMux()
+		state.muxName = r.ServiceNamePriv
+	}
+	mux := state.mux
+	state.mutex.Unlock()
+
+	mux.ServeHTTP(writer, request)
+}
+
+// SetTLSConfig makes the server started by Start serve HTTPS using the given config, which must supply a
+// certificate.  nil, the default, means plain HTTP.  The config is cloned when the server starts.
+func (r *Reporter) SetTLSConfig(config *tls.Config) {
+	r.TLSConfigPriv = config
+}
+
+// SetTLSFiles makes the server started by Start serve HTTPS using the certificate and key in the given PEM
+// files.  It returns an error if the files can't be loaded.
+func (r *Reporter) SetTLSFiles(certFile, keyFile string) error {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	r.SetTLSConfig(&tls.Config{Certificates: []tls.Certificate{certificate}})
+	return nil
+}
+
+// Start listens on the Reporter's host and port and serves requests until the context is cancelled or
+// Shutdown is called.  If a TLS config has been set it serves HTTPS, otherwise HTTP.  It blocks, so it's
+// usually run in a goroutine.  It returns an error if it can't listen or if the server fails.  When the
+// server is shut down it returns nil.
+func (r *Reporter) Start(ctx context.Context) error {
+	address := fmt.Sprintf("%s:%d", r.ServiceHostPriv, r.ServicePortPriv)
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return r.Serve(ctx, listener)
+}
+
+// Serve is like Start but it serves requests arriving on the given listener.  It closes the listener when
+// it returns.
+func (r *Reporter) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{Handler: r}
+
+	if r.TLSConfigPriv != nil {
+		config := r.TLSConfigPriv.Clone()
+		if len(config.Certificates) == 0 && config.GetCertificate == nil {
+			listener.Close()
+			return ErrNoCertificate
+		}
+		listener = tls.NewListener(listener, config)
+	}
+
+	state := r.state()
+	state.mutex.Lock()
+	if state.server != nil {
+		state.mutex.Unlock()
+		listener.Close()
+		return ErrAlreadyStarted
+	}
+	state.server = server
+	state.listener = listener
+	// Shutdown waits for the connections to go idle, which the event streams never do, so end them.
+	shuttingDown := make(chan struct{})
+	state.shuttingDown = shuttingDown
+	server.RegisterOnShutdown(func() { close(shuttingDown) })
+	state.mutex.Unlock()
+
+	defer func() {
+		state.mutex.Lock()
+		state.server = nil
+		state.listener = nil
+		state.shuttingDown = nil
+		state.mutex.Unlock()
+	}()
+
+	// When the context is cancelled, shut down and let in-flight requests finish.
+	served := make(chan struct{})
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		select {
+		case <-ctx.Done():
+			server.Shutdown(context.Background())
+		case <-served:
+		}
+	}()
+
+	err := server.Serve(listener)
+	close(served)
+	// Serve returns as soon as Shutdown is called.  Wait until the in-flight requests have finished.
+	<-shutdownDone
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Shutdown stops the server started by Start.  It stops accepting requests and waits for in-flight requests
+// to finish, or for the context to be cancelled, whichever comes first.  If the server is not running it does
+// nothing.
+func (r *Reporter) Shutdown(ctx context.Context) error {
+	state := r.state()
+	state.mutex.Lock()
+	server := state.server
+	state.mutex.Unlock()
+
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+// Addr returns the address that the server started by Start is listening on, or nil if it's not running.
+// It's useful when the Reporter is started on port 0.
+func (r *Reporter) Addr() net.Addr {
+	state := r.state()
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if state.listener == nil {
+		return nil
+	}
+	return state.listener.Addr()
+}
+
+// StartService starts the web service.  It blocks until the server fails, printing the error.  It's
+// retained for existing callers - Start returns the error instead.  It has a value receiver, as it always
+// had, so it can be called on the result of MakeReporter.  The copy shares the server state created by
+// MakeReporter, so Shutdown and Addr work on the original.
+func (r Reporter) StartService() {
+	scheme := "http"
+	if r.TLSConfigPriv != nil {
+		scheme = "https"
+	}
+	fmt.Fprintf(os.Stderr, "listening for status requests on %s://%s:%d\n", scheme, r.ServiceHostPriv, r.ServicePortPriv)
+	err := r.Start(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot start http server for status requests - %s\n", err.Error())
+	}
+}
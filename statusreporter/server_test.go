@@ -0,0 +1,265 @@
+package statusreporter
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ts "github.com/goblimey/go-tools/testsupport"
+)
+
+// TestTwoReportersInOneProcess checks that two Reporters with different feeds can be served side by side,
+// one of them mounted under a prefix.
+func TestTwoReportersInOneProcess(t *testing.T) {
+	first := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	second := MakeReporter(new(StructuredReportFeedForTest), "localhost", 0)
+	second.SetPathPrefix("/admin/")
+
+	mux := http.NewServeMux()
+	mux.Handle("/status/", &first)
+	mux.Handle("/admin/", http.StripPrefix("/admin", &second))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	body := get(t, server.URL+"/status/report", http.StatusOK)
+	if !strings.Contains(body, "href='/status/stylesheet.css'") {
+		t.Errorf("expected a link to /status/stylesheet.css, got %s", body)
+	}
+
+	body = get(t, server.URL+"/admin/status/report", http.StatusOK)
+	if !strings.Contains(body, "href='/admin/status/stylesheet.css'") {
+		t.Errorf("expected a link to /admin/status/stylesheet.css, got %s", body)
+	}
+	get(t, server.URL+"/admin/status/stylesheet.css", http.StatusOK)
+
+	// Only the second feed supplies a structured report.
+	get(t, server.URL+"/status/report.json", http.StatusNotFound)
+	body = get(t, server.URL+"/admin/status/report.json", http.StatusOK)
+	if body != `{"connections":2,"name":"foo"}` {
+		t.Errorf("unexpected JSON report %s", body)
+	}
+}
+
+// TestStartAndShutdown checks that Start serves requests and that Shutdown stops it cleanly.
+func TestStartAndShutdown(t *testing.T) {
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+
+	result := make(chan error, 1)
+	go func() { result <- reporter.Start(context.Background()) }()
+
+	url := "http://" + waitForAddr(t, &reporter) + "/status/report"
+	body := get(t, url, http.StatusOK)
+	if !strings.Contains(body, "foo") {
+		t.Errorf("expected the report to contain foo, got %s", body)
+	}
+
+	err := reporter.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Shutdown failed - %v", err)
+	}
+	select {
+	case err = <-result:
+		if err != nil {
+			t.Errorf("expected Start to return nil, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Shutdown")
+	}
+	if reporter.Addr() != nil {
+		t.Errorf("expected no address after Shutdown, got %v", reporter.Addr())
+	}
+}
+
+// TestStartService checks that StartService can still be called on the result of MakeReporter and that the
+// original Reporter can shut down the server it starts.
+func TestStartService(t *testing.T) {
+	// This only compiles if StartService has a value receiver.
+	_ = MakeReporter(new(ReportFeedForTest), "localhost", 0).StartService
+
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+
+	done := make(chan struct{})
+	go func() {
+		reporter.StartService()
+		close(done)
+	}()
+
+	url := "http://" + waitForAddr(t, &reporter) + "/status/report"
+	get(t, url, http.StatusOK)
+
+	err := reporter.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Shutdown failed - %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StartService did not return after Shutdown")
+	}
+}
+
+// TestStartStopsWhenContextIsCancelled checks that cancelling the context stops the server.
+func TestStartStopsWhenContextIsCancelled(t *testing.T) {
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	result := make(chan error, 1)
+	go func() { result <- reporter.Start(ctx) }()
+	waitForAddr(t, &reporter)
+
+	// A second server can't be started while the first is running.
+	err := reporter.Start(context.Background())
+	if err != ErrAlreadyStarted {
+		t.Errorf("expected ErrAlreadyStarted, got %v", err)
+	}
+
+	cancel()
+	select {
+	case err = <-result:
+		if err != nil {
+			t.Errorf("expected Start to return nil, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after the context was cancelled")
+	}
+}
+
+// TestStartReturnsListenError checks that Start returns an error if it can't listen.
+func TestStartReturnsListenError(t *testing.T) {
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", -1)
+	err := reporter.Start(context.Background())
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// TestStartWithTLS checks that Start serves HTTPS when given a certificate.
+func TestStartWithTLS(t *testing.T) {
+	testDirName, err := ts.CreateWorkingDirectory()
+	if err != nil {
+		t.Fatalf("createWorkingDirectory failed - %v", err)
+	}
+	defer ts.RemoveWorkingDirectory(testDirName)
+
+	certificate := writeCertificate(t, "cert.pem", "key.pem")
+
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	err = reporter.SetTLSFiles("cert.pem", "key.pem")
+	if err != nil {
+		t.Fatalf("SetTLSFiles failed - %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reporter.Start(ctx)
+	address := waitForAddr(t, &reporter)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	response, err := client.Get("https://" + address + "/status/report")
+	if err != nil {
+		t.Fatalf("HTTPS request failed - %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, response.StatusCode)
+	}
+
+	// A TLS config without a certificate is rejected.
+	other := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	other.SetTLSConfig(&tls.Config{})
+	err = other.Start(context.Background())
+	if err != ErrNoCertificate {
+		t.Errorf("expected ErrNoCertificate, got %v", err)
+	}
+
+	err = other.SetTLSFiles("junk.pem", "junk.key")
+	if err == nil {
+		t.Error("expected an error loading missing files")
+	}
+}
+
+// writeCertificate creates a self-signed certificate for localhost, writes it and its key to the given PEM
+// files and returns it.
+func writeCertificate(t *testing.T, certFile, keyFile string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("cannot generate key - %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("cannot create certificate - %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("cannot parse certificate - %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("cannot marshal key - %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	err = ioutil.WriteFile(certFile, certPEM, 0644)
+	if err == nil {
+		err = ioutil.WriteFile(keyFile, keyPEM, 0600)
+	}
+	if err != nil {
+		t.Fatalf("cannot write certificate - %v", err)
+	}
+	return certificate
+}
+
+// waitForAddr waits until the Reporter is listening and returns its address.
+func waitForAddr(t *testing.T, reporter *Reporter) string {
+	for i := 0; i < 500; i++ {
+		if addr := reporter.Addr(); addr != nil {
+			return addr.String()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the reporter did not start")
+	return ""
+}
+
+// get sends an HTTP GET request, checks the status and returns the body.
+func get(t *testing.T, url string, expectedStatus int) string {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed - %v", url, err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("cannot read the response to GET %s - %v", url, err)
+	}
+	if response.StatusCode != expectedStatus {
+		t.Errorf("GET %s: expected status %d, got %d", url, expectedStatus, response.StatusCode)
+	}
+	return string(body)
+}
@@ -0,0 +1,270 @@
+package statusreporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+)
+
+// StreamRequestEnd defines the end of the HTTP request for the stream of Server-Sent Events.
+const StreamRequestEnd = "/stream"
+
+// LiveRequestEnd defines the end of the HTTP request for the live status page.
+const LiveRequestEnd = "/live"
+
+// DefaultStreamInterval is the default interval between the status snapshots sent on the stream.
+const DefaultStreamInterval = 5 * time.Second
+
+// minSnapshotInterval is the shortest interval between the status snapshots sent on the stream in
+// response to events.
+const minSnapshotInterval = time.Second
+
+// subscriberBufferSize is the number of events that can wait for a slow subscriber.  Further events are
+// dropped.
+const subscriberBufferSize = 64
+
+// Event describes something that happened, for example a connection opening.  Data is converted to JSON.
+type Event struct {
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// EventFeed is an optional interface that a ReportFeedT may also implement.  Subscribe returns a channel
+// of events and a function that cancels the subscription.  The Reporter forwards the events to the browsers
+// watching the stream.  A feed can implement it by embedding a Broadcaster.
+type EventFeed interface {
+	Subscribe() (events <-chan Event, cancel func())
+}
+
+// Broadcaster sends events to any number of subscribers.  Publish never blocks - if a subscriber falls
+// behind, events are dropped.
+type Broadcaster struct {
+	mutex       sync.Mutex
+	subscribers map[chan Event]struct{}
+	clock       clock.Clock // Supplies the time of events that have none.  Nil means the system clock.
+}
+
+// Check that the Broadcaster satisfies the EventFeed interface.
+var _ EventFeed = (*Broadcaster)(nil)
+
+// NewBroadcaster creates and returns a Broadcaster.
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{subscribers: make(map[chan Event]struct{})}
+}
+
+// SetClock sets the clock that supplies the time of events published without one.  It's intended for use
+// in tests.  The default is the system clock.
+func (b *Broadcaster) SetClock(cl clock.Clock) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.clock = cl
+}
+
+// Subscribe satisfies the EventFeed interface.
+func (b *Broadcaster) Subscribe() (<-chan Event, func()) {
+	events := make(chan Event, subscriberBufferSize)
+	b.mutex.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan Event]struct{})
+	}
+	b.subscribers[events] = struct{}{}
+	b.mutex.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.subscribers, events)
+			b.mutex.Unlock()
+			close(events)
+		})
+	}
+	return events, cancel
+}
+
+// Publish sends an event to the subscribers.  If the event has no time, the current time is used.
+func (b *Broadcaster) Publish(event Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if event.Time.IsZero() {
+		if b.clock != nil {
+			event.Time = b.clock.Now()
+		} else {
+			event.Time = time.Now()
+		}
+	}
+	for subscriber := range b.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// SetStreamInterval sets the interval between the status snapshots sent on the stream.  The default is
+// DefaultStreamInterval.
+func (r *Reporter) SetStreamInterval(interval time.Duration) {
+	r.StreamIntervalPriv = interval
+}
+
+// events returns the Broadcaster for the events produced by the Reporter itself, such as a change of log
+// level.
+func (r *Reporter) events() *Broadcaster {
+	state := r.state()
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.events == nil {
+		state.events = NewBroadcaster()
+	}
+	return state.events
+}
+
+// publish sends an event produced by the Reporter itself to the streams, timed by the Reporter's clock.
+func (r *Reporter) publish(event Event) {
+	event.Time = r.timerClock().Now()
+	r.events().Publish(event)
+}
+
+// statusSnapshot is the data of the "status" event sent on the stream.
+type statusSnapshot struct {
+	Report     string      `json:"report"`               // The status report as produced by the feed's Status method.
+	Structured interface{} `json:"structured,omitempty"` // The structured status, if the feed supplies it.
+}
+
+// HandleStreamRequest sends a stream of Server-Sent Events.  A "status" event containing a snapshot of the
+// status is sent when the stream starts, at regular intervals and, no more than once a second, after other
+// events.  Events from the Reporter, such as a log level change, and from the feed, if it implements
+// EventFeed, are sent as unnamed events whose data is the JSON form of the Event.  The stream ends when the
+// browser goes away or the server shuts down.
+func (r *Reporter) HandleStreamRequest(writer http.ResponseWriter, request *http.Request) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	reporterEvents, cancelReporterEvents := r.events().Subscribe()
+	defer cancelReporterEvents()
+	var feedEvents <-chan Event
+	if feed, ok := r.ReportFeedPriv.(EventFeed); ok {
+		var cancel func()
+		feedEvents, cancel = feed.Subscribe()
+		defer cancel()
+	}
+
+	interval := r.StreamIntervalPriv
+	if interval <= 0 {
+		interval = DefaultStreamInterval
+	}
+	cl := r.timerClock()
+	ticker := cl.NewTicker(interval)
+	defer ticker.Stop()
+
+	header := writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // Stop nginx from buffering the stream.
+
+	lastSnapshot := cl.Now()
+	err := r.writeSnapshot(writer)
+	for err == nil {
+		flusher.Flush()
+		select {
+		case <-request.Context().Done():
+			return
+		case <-r.shuttingDown():
+			return
+		case <-ticker.C():
+			lastSnapshot = cl.Now()
+			err = r.writeSnapshot(writer)
+		case event, ok := <-reporterEvents:
+			if !ok {
+				return
+			}
+			err = writeEvent(writer, "", event)
+		case event, ok := <-feedEvents:
+			if !ok {
+				return
+			}
+			err = writeEvent(writer, "", event)
+		}
+		if err == nil && cl.Now().Sub(lastSnapshot) >= minSnapshotInterval {
+			lastSnapshot = cl.Now()
+			err = r.writeSnapshot(writer)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "error writing event stream - %s\n", err.Error())
+}
+
+// shuttingDown returns a channel that's closed when the server started by Start begins to shut down.  If
+// the server wasn't started by Start, the channel is nil.
+func (r *Reporter) shuttingDown() <-chan struct{} {
+	state := r.state()
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	return state.shuttingDown
+}
+
+// writeSnapshot writes a "status" event containing a snapshot of the status.
+func (r *Reporter) writeSnapshot(w io.Writer) error {
+	snapshot := statusSnapshot{Report: string(r.ReportFeedPriv.Status())}
+	if feed, ok := r.ReportFeedPriv.(StructuredReportFeed); ok {
+		snapshot.Structured = feed.StructuredStatus()
+	}
+	return writeEvent(w, "status", snapshot)
+}
+
+// writeEvent writes a Server-Sent Event with the given name, or an unnamed event if the name is "".  The
+// data is written as JSON.
+func writeEvent(w io.Writer, name string, data interface{}) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if name != "" {
+		fmt.Fprintf(&b, "event: %s\n", name)
+	}
+	// JSON has no raw newlines, so the data fits on one line.
+	fmt.Fprintf(&b, "data: %s\n\n", body)
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// LivePage contains the data for the live status page.
+type LivePage struct {
+	PageTitle   string
+	PathPrefix  string
+	ServiceName string
+	StreamURL   string
+	// TrustedHTML is true if the feed's status report contains HTML that may be displayed as it is.
+	TrustedHTML bool
+}
+
+// HandleLiveRequest handles the request for the live status page, which displays the status and the events
+// from the stream and updates itself in place.
+func (r *Reporter) HandleLiveRequest(writer http.ResponseWriter, request *http.Request) {
+	if r.LiveTemplate == nil {
+		r.InitTemplates()
+	}
+	page := LivePage{
+		PageTitle:   "Live Status",
+		PathPrefix:  r.PathPrefixPriv,
+		ServiceName: r.ServiceNamePriv,
+		StreamURL:   r.PathPrefixPriv + r.StreamRequestPriv,
+		TrustedHTML: r.UseTextTemplates,
+	}
+	err := r.LiveTemplate.Execute(writer, page)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error displaying live page - %s\n", err.Error())
+		writer.Write(internalErrorPage)
+	}
+}
@@ -0,0 +1,211 @@
+package statusreporter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goblimey/go-tools/clock"
+)
+
+// TestBroadcaster checks that events reach the subscribers and that a slow subscriber doesn't block.
+func TestBroadcaster(t *testing.T) {
+	broadcaster := NewBroadcaster()
+	first, cancelFirst := broadcaster.Subscribe()
+	second, cancelSecond := broadcaster.Subscribe()
+
+	broadcaster.Publish(Event{Type: "test", Data: 1})
+	for _, events := range []<-chan Event{first, second} {
+		event := <-events
+		if event.Type != "test" || event.Data != 1 || event.Time.IsZero() {
+			t.Errorf("unexpected event %+v", event)
+		}
+	}
+
+	// An event without a time is stamped by the Broadcaster's clock.
+	start := time.Date(2020, time.February, 14, 12, 0, 0, 0, time.UTC)
+	broadcaster.SetClock(clock.NewManualClock(start))
+	broadcaster.Publish(Event{Type: "test"})
+	for _, events := range []<-chan Event{first, second} {
+		if event := <-events; !event.Time.Equal(start) {
+			t.Errorf("expected the event at %v, got %v", start, event.Time)
+		}
+	}
+
+	// Nobody reads the second subscription, so it fills up and events are dropped.
+	cancelFirst()
+	for i := 0; i < 2*subscriberBufferSize; i++ {
+		broadcaster.Publish(Event{Type: "test"})
+	}
+	if len(second) != subscriberBufferSize {
+		t.Errorf("expected %d events waiting, got %d", subscriberBufferSize, len(second))
+	}
+
+	// Cancelling closes the channel.  Cancelling twice is harmless.
+	cancelSecond()
+	cancelSecond()
+	for range second {
+	}
+	if _, ok := <-first; ok {
+		t.Error("expected the first channel to be closed")
+	}
+}
+
+// TestStreamRequest checks the event stream.
+func TestStreamRequest(t *testing.T) {
+	feed := &EventFeedForTest{Broadcaster: NewBroadcaster()}
+	reporter := MakeReporter(feed, "localhost", 0)
+	server := httptest.NewServer(&reporter)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/status/stream")
+	if err != nil {
+		t.Fatalf("GET failed - %v", err)
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("unexpected content type %s", response.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(response.Body)
+
+	// The stream starts with a snapshot.
+	name, data := readEvent(t, reader)
+	if name != "status" || data != `{"report":"foo"}` {
+		t.Errorf("expected a status snapshot, got %s %s", name, data)
+	}
+
+	// A log level change produces an event.
+	_, err = http.Post(server.URL+"/status/loglevel/3", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("POST failed - %v", err)
+	}
+	event := readUnnamedEvent(t, reader)
+	level, _ := event.Data.(map[string]interface{})
+	if event.Type != "loglevel" || level["level"] != 3.0 {
+		t.Errorf("expected a loglevel event, got %+v", event)
+	}
+
+	// So does the feed.
+	feed.Publish(Event{Type: "connection", Data: "opened"})
+	event = readUnnamedEvent(t, reader)
+	if event.Type != "connection" || event.Data != "opened" {
+		t.Errorf("expected a connection event, got %+v", event)
+	}
+}
+
+// TestStreamUsesClock checks that the snapshots and the Reporter's events are timed by the Reporter's
+// clock.
+func TestStreamUsesClock(t *testing.T) {
+	start := time.Date(2020, time.February, 14, 12, 0, 0, 0, time.UTC)
+	manualClock := clock.NewManualClock(start)
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	reporter.SetClock(manualClock)
+	reporter.SetStreamInterval(10 * time.Second)
+	server := httptest.NewServer(&reporter)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/status/stream")
+	if err != nil {
+		t.Fatalf("GET failed - %v", err)
+	}
+	defer response.Body.Close()
+	reader := bufio.NewReader(response.Body)
+	readEvent(t, reader)
+
+	// The next snapshot is sent when the clock reaches the end of the interval.
+	manualClock.BlockUntil(1)
+	manualClock.Advance(10 * time.Second)
+	name, _ := readEvent(t, reader)
+	if name != "status" {
+		t.Errorf("expected a status snapshot, got event %s", name)
+	}
+
+	// A log level change is stamped with the clock's time.
+	_, err = http.Post(server.URL+"/status/loglevel/3", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("POST failed - %v", err)
+	}
+	event := readUnnamedEvent(t, reader)
+	if !event.Time.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("expected the event at %v, got %v", start.Add(10*time.Second), event.Time)
+	}
+}
+
+// TestShutdownEndsStreams checks that Shutdown doesn't wait for the event streams.
+func TestShutdownEndsStreams(t *testing.T) {
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	go reporter.Start(context.Background())
+	address := waitForAddr(t, &reporter)
+
+	response, err := http.Get("http://" + address + "/status/stream")
+	if err != nil {
+		t.Fatalf("GET failed - %v", err)
+	}
+	defer response.Body.Close()
+	readEvent(t, bufio.NewReader(response.Body))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = reporter.Shutdown(ctx)
+	if err != nil {
+		t.Errorf("Shutdown failed - %v", err)
+	}
+}
+
+// TestLiveRequest checks that the live page connects to the stream.
+func TestLiveRequest(t *testing.T) {
+	reporter := MakeReporter(new(ReportFeedForTest), "localhost", 0)
+	reporter.SetPathPrefix("/admin")
+	request := httptest.NewRequest("GET", "/status/live", nil)
+	recorder := httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, request)
+
+	body := recorder.Body.String()
+	if !strings.Contains(body, `new EventSource("/admin/status/stream")`) {
+		t.Errorf("expected the page to open the stream, got %s", body)
+	}
+	if !strings.Contains(body, "var trustedHTML =  false ;") {
+		t.Errorf("expected the report to be untrusted, got %s", body)
+	}
+}
+
+// readUnnamedEvent reads the next unnamed event from a stream, skipping status snapshots.
+func readUnnamedEvent(t *testing.T, reader *bufio.Reader) Event {
+	for {
+		name, data := readEvent(t, reader)
+		if name != "" {
+			continue
+		}
+		var event Event
+		err := json.Unmarshal([]byte(data), &event)
+		if err != nil {
+			t.Fatalf("cannot decode event %s - %v", data, err)
+		}
+		return event
+	}
+}
+
+// readEvent reads a Server-Sent Event and returns its name and data.
+func readEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	var name, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("cannot read event - %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
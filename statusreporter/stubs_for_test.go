@@ -29,6 +29,45 @@ func (trf ReportFeedForTest) GetLogLevel() uint8 {
 	return trf.LogLevel
 }
 
+// StructuredReportFeedForTest respects the status-reporter ReportFeedT and
+// StructuredReportFeed interfaces.
+type StructuredReportFeedForTest struct {
+	ReportFeedForTest
+}
+
+// StructuredStatus satisfies the StructuredReportFeed interface.
+func (trf *StructuredReportFeedForTest) StructuredStatus() interface{} {
+	return map[string]interface{}{"connections": 2, "name": "foo"}
+}
+
+// MetricsFeedForTest respects the status-reporter ReportFeedT and MetricsFeed interfaces.
+type MetricsFeedForTest struct {
+	ReportFeedForTest
+}
+
+// Metrics satisfies the MetricsFeed interface.
+func (trf *MetricsFeedForTest) Metrics() []Metric {
+	return []Metric{
+		{Name: "test_bytes_total", Help: "Bytes seen.", Type: Counter,
+			Labels: map[string]string{"direction": "in"}, Value: 42},
+		{Name: "test_open", Type: Gauge, Value: 1.5},
+		{Name: "test_bytes_total", Help: "Bytes seen.", Type: Counter,
+			Labels: map[string]string{"direction": "out", "peer": "a \"b\""}, Value: 7},
+	}
+}
+
+// EventFeedForTest respects the status-reporter ReportFeedT and EventFeed interfaces.
+type EventFeedForTest struct {
+	ReportFeedForTest
+	*Broadcaster
+}
+
+// HealthFeedForTest respects the status-reporter ReportFeedT and HealthFeed interfaces.
+type HealthFeedForTest struct {
+	ReportFeedForTest
+	HealthRegistry
+}
+
 // ResponseWriterForTest satisfies the http.ResponseWriter interface and logs what is written.
 type ResponseWriterForTest struct {
 
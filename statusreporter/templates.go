@@ -41,7 +41,7 @@ var baseText = `
     <head>
         <meta charset="UTF-8">
         <title>{{ template "PageTitle" .}}</title>
-        <link href='/{{ template "ServiceName" .}}/stylesheet.css' rel='stylesheet'/>
+        <link href='{{ template "PathPrefix" .}}/{{ template "ServiceName" .}}/stylesheet.css' rel='stylesheet'/>
     </head>
     <body>
     	 <h2>{{ template "PageTitle" .}}</h2>
@@ -54,9 +54,18 @@ var baseText = `
 
 var reportText = `
 {{define "PageTitle"}}{{.PageTitle}}{{end}}
+{{define "PathPrefix"}}{{.PathPrefix}}{{end}}
 {{define "ServiceName"}}{{.ServiceName}}{{end}}
 {{define "content"}}
 {{.Content}}
+{{if .LogLevel}}<p id='loglevel'>Log level {{.LogLevel}}</p>{{end}}
+{{if .Commands}}
+<h3>Commands</h3>
+<p>POST {{.PathPrefix}}/{{.ServiceName}}/command/{name}?{parameters}</p>
+<ul id='commands'>
+{{range .Commands}}<li><code>{{.Usage}}</code> {{.Help}}</li>
+{{end}}</ul>
+{{end}}
 {{end}}
 `
 var errorText = `
@@ -68,3 +77,46 @@ var errorText = `
 </div>
 {{end}}
 `
+
+// liveText is the live status page.  It displays the status snapshots from the
+// event stream and a list of the latest events.  If the feed's report is
+// trusted HTML it's displayed as HTML, otherwise as text.
+var liveText = `
+{{define "PageTitle"}}{{.PageTitle}}{{end}}
+{{define "PathPrefix"}}{{.PathPrefix}}{{end}}
+{{define "ServiceName"}}{{.ServiceName}}{{end}}
+{{define "content"}}
+<div id='report'>connecting ...</div>
+<h3>Events</h3>
+<div class="preformatted" id='events'></div>
+<script>
+(function() {
+	var trustedHTML = {{.TrustedHTML}};
+	var maxEvents = 50;
+	var report = document.getElementById('report');
+	var events = document.getElementById('events');
+	var source = new EventSource({{.StreamURL}});
+	source.addEventListener('status', function(e) {
+		var status = JSON.parse(e.data);
+		if (trustedHTML) {
+			report.innerHTML = status.report;
+		} else {
+			report.textContent = status.report;
+		}
+	});
+	source.onmessage = function(e) {
+		var event = JSON.parse(e.data);
+		var line = document.createElement('div');
+		line.textContent = event.time + ' ' + event.type + ' ' + JSON.stringify(event.data);
+		events.insertBefore(line, events.firstChild);
+		while (events.childNodes.length > maxEvents) {
+			events.removeChild(events.lastChild);
+		}
+	};
+	source.onerror = function() {
+		report.textContent = 'disconnected - retrying ...';
+	};
+})();
+</script>
+{{end}}
+`
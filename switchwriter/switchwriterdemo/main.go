@@ -27,7 +27,7 @@ func main() {
 //
 var logDir = "logs/"
 var logFilePrefix = "log-number-"
-var logFile = switchwriter.New()
+var logFile = switchwriter.NewOwner() // Closes each log file when it switches to the next.
 
 // Make sure the log directory exists and is empty
 //
@@ -2,6 +2,7 @@ package switchwriter
 
 import (
 	"io"
+	"reflect"
 	"sync"
 )
 
@@ -11,14 +12,21 @@ import (
 //
 // All Writers are initially created disabled.
 //
+// A Writer created by NewOwner owns its destinations.  When SwitchTo or Close
+// replaces a destination that is an io.Closer, the Writer closes it, which also
+// flushes any uncommitted writes.  A Writer created by New leaves that to the
+// caller.
+//
 type Writer struct {
 	sync.Mutex
-	dest io.Writer // The current destination stream.
+	dest  io.Writer // The current destination stream.
+	owner bool      // True if the Writer closes destinations that it replaces.
 }
 
-// A compile-time check that Writer implements io.Writer.
+// A compile-time check that Writer implements io.Writer and io.Closer.
 //
 var _ io.Writer = New()
+var _ io.Closer = New()
 
 // New creates a new, initially disabled, Writer.
 //
@@ -26,14 +34,71 @@ func New() *Writer {
 	return new(Writer)
 }
 
-// SwitchTo(w) switches the destination of future Write()s.
-// If w is nil future Write()s will be silently discarded.
+// NewOwner creates a new, initially disabled, Writer that closes each
+// destination when it's replaced.
+//
+func NewOwner() *Writer {
+	return &Writer{owner: true}
+}
+
+// SwitchTo(w) switches the destination of future Write()s and returns the
+// previous destination, which may be nil.  If w is nil future Write()s will
+// be silently discarded.
+//
+// If the Writer owns its destinations and the previous destination is an
+// io.Closer, SwitchTo closes it and returns any error from that.  Switching to
+// the current destination does nothing.
 //
-func (sw *Writer) SwitchTo(w io.Writer) {
+func (sw *Writer) SwitchTo(w io.Writer) (io.Writer, error) {
 	sw.Lock()
 	defer sw.Unlock()
 
+	previous := sw.dest
 	sw.dest = w // Change the destination for future Write()s.
+
+	if sw.owner && previous != nil && !sameWriter(previous, w) {
+		if closer, ok := previous.(io.Closer); ok {
+			return previous, closer.Close()
+		}
+	}
+
+	return previous, nil
+}
+
+// sameWriter returns true if a and b are the same destination.  Comparing two
+// interface values whose dynamic type isn't comparable panics, so sameWriter
+// checks the types first.  Maps, slices and funcs aren't comparable but they
+// refer to something, so they are the same destination if they refer to the
+// same thing.  Any other value that isn't comparable is the same destination if
+// it's equal.
+//
+func sameWriter(a, b io.Writer) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) {
+		return false
+	}
+	if t.Comparable() {
+		return a == b
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch t.Kind() {
+	case reflect.Map, reflect.Func:
+		return va.Pointer() == vb.Pointer()
+	case reflect.Slice:
+		return va.Pointer() == vb.Pointer() && va.Len() == vb.Len()
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Close disables the Writer.  If the Writer owns its destinations, Close also
+// closes the current destination if it is an io.Closer.
+//
+func (sw *Writer) Close() error {
+	_, err := sw.SwitchTo(nil)
+	return err
 }
 
 // Write(buf) writes to the current destination.
@@ -0,0 +1,139 @@
+package switchwriter
+
+import (
+	"bytes"
+	"testing"
+)
+
+// closer is a destination that records whether it has been closed.
+type closer struct {
+	bytes.Buffer
+	closed bool
+}
+
+// Close satisfies the io.Closer interface.
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+// sliceWriter is a destination whose type isn't comparable.
+type sliceWriter struct {
+	lines []string
+}
+
+// Write satisfies the io.Writer interface.
+func (w sliceWriter) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+// mapWriter is a destination whose type isn't comparable but which refers to
+// its contents, as a pointer does.  It counts the times it's been closed.
+type mapWriter map[string]int
+
+// Write satisfies the io.Writer interface.
+func (w mapWriter) Write(buf []byte) (int, error) {
+	w["written"] += len(buf)
+	return len(buf), nil
+}
+
+// Close satisfies the io.Closer interface.
+func (w mapWriter) Close() error {
+	w["closed"]++
+	return nil
+}
+
+// TestOwnerClosesOnSwitch checks that a Writer created by NewOwner closes the
+// old destination when it switches and the current one when it's closed.
+func TestOwnerClosesOnSwitch(t *testing.T) {
+	first := &closer{}
+	second := &closer{}
+	sw := NewOwner()
+
+	sw.SwitchTo(first)
+	sw.Write([]byte("hello"))
+	if first.String() != "hello" {
+		t.Errorf("expected the first destination to contain \"hello\", got \"%s\"", first.String())
+	}
+
+	// Switching to the current destination doesn't close it.
+	sw.SwitchTo(first)
+	if first.closed {
+		t.Error("switching to the current destination closed it")
+	}
+
+	previous, err := sw.SwitchTo(second)
+	if err != nil {
+		t.Fatalf("SwitchTo failed - %v", err)
+	}
+	if previous != first || !first.closed {
+		t.Error("expected the first destination to be returned and closed")
+	}
+	if second.closed {
+		t.Error("the new destination should not be closed")
+	}
+
+	err = sw.Close()
+	if err != nil {
+		t.Fatalf("Close failed - %v", err)
+	}
+	if !second.closed {
+		t.Error("expected Close to close the current destination")
+	}
+
+	// The Writer is now disabled.
+	n, err := sw.Write([]byte("discarded"))
+	if n != len("discarded") || err != nil {
+		t.Errorf("expected a disabled Write to return %d, nil, got %d, %v", len("discarded"), n, err)
+	}
+}
+
+// TestNotOwnerLeavesOpen checks that a Writer created by New doesn't close its
+// destinations.
+func TestNotOwnerLeavesOpen(t *testing.T) {
+	first := &closer{}
+	sw := New()
+	sw.SwitchTo(first)
+	sw.SwitchTo(nil)
+	sw.SwitchTo(first)
+	sw.Close()
+	if first.closed {
+		t.Error("a Writer created by New closed its destination")
+	}
+}
+
+// TestSwitchNonComparable checks that switching between destinations whose type
+// isn't comparable doesn't panic.
+func TestSwitchNonComparable(t *testing.T) {
+	sw := NewOwner()
+	sw.SwitchTo(sliceWriter{})
+	sw.SwitchTo(sliceWriter{})
+	sw.Close()
+}
+
+// TestSwitchToSame checks that switching to the current destination leaves it
+// open, whether or not its type is comparable, and that switching to another
+// destination of the same type closes it.
+func TestSwitchToSame(t *testing.T) {
+	sw := NewOwner()
+	first := &closer{}
+	sw.SwitchTo(first)
+	sw.SwitchTo(first)
+	if first.closed {
+		t.Error("expected switching to the same destination to leave it open")
+	}
+
+	m := mapWriter{}
+	sw.SwitchTo(m)
+	sw.SwitchTo(m)
+	sw.Write([]byte("hello"))
+	if m["closed"] != 0 || m["written"] != 5 {
+		t.Errorf("expected the map destination to be open and written to, got %v", m)
+	}
+
+	sw.SwitchTo(mapWriter{})
+	if m["closed"] != 1 {
+		t.Errorf("expected switching to another map destination to close the first, got %v", m)
+	}
+	sw.Close()
+}